@@ -17,28 +17,143 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // AddonSpec defines the desired state of Addon
 type AddonSpec struct {
-	Id               int    `json:"id"`
-	Name             string `json:"name"`
-	OciRegistry      string `json:"ociRegistry"`
-	OciVersion       string `json:"ociVersion"`
-	PullPolicy       string `json:"pullPolicy,omitempty"`
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	OciRegistry string `json:"ociRegistry"`
+	// OciVersion is the package version installed,
+	// unless the addon follows a Channel
+	OciVersion string `json:"ociVersion,omitempty"`
+	// Channel is a semver constraint, such as ~1.4. When set, the
+	// registry is checked periodically and the highest matching
+	// version is installed instead of OciVersion
+	Channel string `json:"channel,omitempty"`
+	// PullPolicy is one of Always, IfNotPresent or Never
+	PullPolicy string `json:"pullPolicy,omitempty"`
+	// ActivationPolicy is either Automatic or Manual
 	ActivationPolicy string `json:"activationPolicy,omitempty"`
+
+	// RevisionHistoryLimit is how many inactive package
+	// revisions are kept. Defaults to 10
+	// +kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int64 `json:"revisionHistoryLimit,omitempty"`
+
+	// PackagePullSecrets are Secrets in the Crossplane
+	// namespace used to pull the package
+	PackagePullSecrets []v1.LocalObjectReference `json:"packagePullSecrets,omitempty"`
+
+	// SkipDependencyResolution installs the package
+	// without installing its dependencies
+	SkipDependencyResolution bool `json:"skipDependencyResolution,omitempty"`
+
+	// IgnoreCrossplaneConstraints installs the package even if it
+	// doesn't support the version of Crossplane running
+	IgnoreCrossplaneConstraints bool `json:"ignoreCrossplaneConstraints,omitempty"`
+
+	// ActiveRevision is the ConfigurationRevision to activate when the
+	// activation policy is Manual. While set, the Configuration installs
+	// the package of that revision instead of OciVersion, so that
+	// previous revisions can be rolled back to
+	ActiveRevision string `json:"activeRevision,omitempty"`
+}
+
+// Activation policies of an addon's package revisions
+const (
+	AddonActivationAutomatic = "Automatic"
+	AddonActivationManual    = "Manual"
+)
+
+// Condition types reported in AddonStatus.Conditions
+const (
+	// AddonReady is true once the package is installed and healthy
+	AddonReady = "Ready"
+
+	// AddonInstalled mirrors the Installed condition of the Configuration
+	AddonInstalled = "Installed"
+
+	// AddonHealthy mirrors the Healthy condition of the Configuration
+	AddonHealthy = "Healthy"
+
+	// AddonPackagePulled is false while the package can't be pulled
+	AddonPackagePulled = "PackagePulled"
+
+	// AddonDependenciesResolved is false while dependencies of
+	// the current package revision are missing or invalid
+	AddonDependenciesResolved = "DependenciesResolved"
+
+	// AddonVersionResolved is false while no version matching the
+	// Channel was found, and is only reported when Channel is set
+	AddonVersionResolved = "VersionResolved"
+
+	// AddonRevisionActivated is true once the ActiveRevision is
+	// active, and is only reported when ActiveRevision is set
+	AddonRevisionActivated = "RevisionActivated"
+)
+
+// AddonRevision is a ConfigurationRevision of the addon package
+type AddonRevision struct {
+	// Name of the ConfigurationRevision
+	Name string `json:"name"`
+
+	// Revision is the revision number, increasing
+	// each time a new package is pulled
+	Revision int64 `json:"revision"`
+
+	// Package the revision was created from
+	Package string `json:"package"`
+
+	// Digest is the short package digest the revision is named after
+	Digest string `json:"digest,omitempty"`
+
+	// Active is true if the revision is, or is being, activated
+	Active bool `json:"active"`
+
+	// Healthy mirrors the Healthy condition of the revision
+	Healthy bool `json:"healthy"`
 }
 
 // AddonStatus defines the observed state of Addon
 type AddonStatus struct {
-	Ready      bool               `json:"ready,omitempty"`
+	// Ready is true once the package is installed and healthy
+	Ready bool `json:"ready,omitempty"`
+
+	// ObservedGeneration is the most recent generation reconciled by the operator
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// CurrentRevision is the ConfigurationRevision
+	// of the most recently pulled package
+	CurrentRevision string `json:"currentRevision,omitempty"`
+
+	// CurrentIdentifier is the package the current revision was created from
+	CurrentIdentifier string `json:"currentIdentifier,omitempty"`
+
+	// ResolvedVersion is the version the Channel was last resolved to
+	ResolvedVersion string `json:"resolvedVersion,omitempty"`
+
+	// LastVersionCheckTime is when the registry was
+	// last checked for versions matching the Channel
+	LastVersionCheckTime *metav1.Time `json:"lastVersionCheckTime,omitempty"`
+
+	// Revisions are the revisions of the package
+	// still kept by Crossplane, newest first
+	Revisions []AddonRevision `json:"revisions,omitempty"`
+
 	Conditions []metav1.Condition `json:"conditions"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:resource:scope=Cluster
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Package",type=string,JSONPath=`.status.currentIdentifier`
+//+kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.currentRevision`
+//+kubebuilder:printcolumn:name="Healthy",type=string,JSONPath=`.status.conditions[?(@.type=="Healthy")].status`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Addon is the Schema for the addons API
 type Addon struct {
@@ -41,6 +41,103 @@ type ClusterSpec struct {
 	Ingress ClusterIngressSpec `json:"ingress"`
 
 	Agent ClusterAgentSpec `json:"agent"`
+
+	// VolumeSnapshotClassName is used to snapshot the vcluster data volume
+	// of a project before upgrading its Kubernetes version. Snapshots
+	// are skipped when unset
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// Vcluster configures the vcluster release of every project
+	Vcluster ClusterVclusterSpec `json:"vcluster,omitempty"`
+
+	// VclusterValues are merged over the values rendered for every
+	// project's vcluster release, in order. ConfigMaps are read
+	// from the namespace of the Cluster
+	VclusterValues []ValuesSource `json:"vclusterValues,omitempty"`
+
+	// Charts overrides where the vcluster and agent charts are pulled
+	// from, such as mirrors reachable from air-gapped environments
+	Charts ClusterChartsSpec `json:"charts,omitempty"`
+}
+
+type ClusterVclusterSpec struct {
+	// ChartVersion is the default vcluster chart version of projects.
+	// Changes are rolled out to MaxConcurrentUpgrades projects at a
+	// time. The latest chart is used when unset
+	ChartVersion string `json:"chartVersion,omitempty"`
+
+	// MaxConcurrentUpgrades is how many projects may upgrade to
+	// a new default chart version at the same time
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	MaxConcurrentUpgrades int32 `json:"maxConcurrentUpgrades,omitempty"`
+}
+
+// ValuesSource is a set of Helm values. Exactly one
+// of Values or ConfigMapKeyRef should be set
+type ValuesSource struct {
+	// Values are inline Helm values, as YAML
+	Values string `json:"values,omitempty"`
+
+	// ConfigMapKeyRef is a ConfigMap key holding Helm values, as YAML
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+type ClusterChartsSpec struct {
+	// Vcluster is the source of the vcluster charts, holding the vcluster,
+	// vcluster-k0s and vcluster-k8s charts. Defaults to https://charts.loft.sh
+	Vcluster *ChartSource `json:"vcluster,omitempty"`
+
+	// Agent is the source of the agent chart.
+	// Defaults to oci://ghcr.io/launchboxio/agent/helm
+	Agent *ChartSource `json:"agent,omitempty"`
+}
+
+// ChartSource is where charts are pulled from. Exactly one
+// of Repository, OCI or Tarball should be set. Secrets and
+// ConfigMaps are read from the namespace of the Cluster
+type ChartSource struct {
+	// Repository is a Helm chart repository
+	Repository *HelmRepositorySource `json:"repository,omitempty"`
+
+	// OCI is an OCI registry hosting the charts
+	OCI *OCIRegistrySource `json:"oci,omitempty"`
+
+	// Tarball is a set of chart archives available to the operator
+	Tarball *ChartTarballSource `json:"tarball,omitempty"`
+}
+
+type HelmRepositorySource struct {
+	// URL is the URL of the chart repository
+	URL string `json:"url"`
+
+	// AuthSecretRef is a Secret with the username and password keys used
+	// to authenticate to the repository, and an optional ca.crt key
+	AuthSecretRef *v1.LocalObjectReference `json:"authSecretRef,omitempty"`
+
+	// InsecureSkipTLSVerify skips verifying the repository certificate
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+type OCIRegistrySource struct {
+	// URL is the registry path the charts are pushed to,
+	// such as oci://registry.example.com/charts
+	URL string `json:"url"`
+
+	// PullSecretRef is a kubernetes.io/dockerconfigjson
+	// Secret used to authenticate to the registry
+	PullSecretRef *v1.LocalObjectReference `json:"pullSecretRef,omitempty"`
+}
+
+// ChartTarballSource reads packaged charts named after the chart,
+// such as vcluster.tgz or agent.tgz. Exactly one of ConfigMapRef
+// or Path should be set
+type ChartTarballSource struct {
+	// ConfigMapRef is a ConfigMap holding the chart archives in its binaryData
+	ConfigMapRef *v1.LocalObjectReference `json:"configMapRef,omitempty"`
+
+	// Path is a directory mounted into the operator holding the chart archives
+	Path string `json:"path,omitempty"`
 }
 
 type ClusterLaunchboxSpec struct {
@@ -71,6 +168,11 @@ type ClusterIngressSpec struct {
 
 	// Domain is the root domain to use for guest cluster access
 	Domain string `json:"domain"`
+
+	// Namespace is where the ingress controller runs. Project
+	// namespaces accept traffic from it
+	// +kubebuilder:default=ingress-nginx
+	Namespace string `json:"namespace,omitempty"`
 }
 
 type ClusterAgentSpec struct {
@@ -82,19 +184,68 @@ type ClusterAgentSpec struct {
 	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
 }
 
+// Condition types reported in ClusterStatus.Conditions
+const (
+	// ClusterReady is true when projects can be provisioned on the cluster
+	ClusterReady = "Ready"
+
+	// ClusterAgentInstalled is true when the agent release is deployed
+	ClusterAgentInstalled = "AgentInstalled"
+
+	// ClusterAgentAvailable is true when the agent Deployment is available
+	ClusterAgentAvailable = "AgentAvailable"
+
+	// ClusterConnected is true while the agent heartbeat is renewed
+	ClusterConnected = "Connected"
+
+	// ClusterDeletionBlocked is true while a deleted cluster
+	// is still referenced by projects
+	ClusterDeletionBlocked = "DeletionBlocked"
+)
+
 // ClusterStatus defines the observed state of Cluster
 type ClusterStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Agent reports the state of the agent release
+	Agent *ClusterAgentStatus `json:"agent,omitempty"`
+
+	// BlockingProjects lists the projects, as namespace/name,
+	// that prevent the deletion of the cluster
+	BlockingProjects []string `json:"blockingProjects,omitempty"`
+
 	Conditions []metav1.Condition `json:"conditions"`
 }
 
+type ClusterAgentStatus struct {
+	// ChartVersion is the version of the installed agent chart
+	ChartVersion string `json:"chartVersion,omitempty"`
+
+	// ReleaseStatus is the status of the agent Helm release
+	ReleaseStatus string `json:"releaseStatus,omitempty"`
+
+	// ReleaseRevision is the revision of the agent Helm release
+	ReleaseRevision int `json:"releaseRevision,omitempty"`
+
+	// AvailableReplicas is the number of available agent replicas
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+
+	// LastHeartbeatTime is when the agent last renewed its lease
+	LastHeartbeatTime *metav1.MicroTime `json:"lastHeartbeatTime,omitempty"`
+
+	// ValuesHash is the hash of the chart and values of the
+	// last successful agent install or upgrade
+	ValuesHash string `json:"valuesHash,omitempty"`
+}
+
 func (c *Cluster) GetConditions() []metav1.Condition {
 	return c.Status.Conditions
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Agent",type=string,JSONPath=`.status.agent.chartVersion`
+//+kubebuilder:printcolumn:name="Connected",type=string,JSONPath=`.status.conditions[?(@.type=="Connected")].status`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Cluster is the Schema for the clusters API
 type Cluster struct {
@@ -17,6 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -30,13 +33,89 @@ type ProjectSpec struct {
 
 	Paused bool `json:"paused,omitempty"`
 
-	KubernetesVersion string                `json:"kubernetesVersion"`
-	Resources         Resources             `json:"resources,omitempty"`
-	IngressHost       string                `json:"ingressHost,omitempty"`
-	Users             []ProjectUser         `json:"users,omitempty"`
-	Crossplane        ProjectCrossplaneSpec `json:"crossplane,omitempty"`
+	// Hibernation pauses the project automatically, on a
+	// schedule or after a period of inactivity
+	Hibernation *ProjectHibernationSpec `json:"hibernation,omitempty"`
+
+	// KubernetesVersion is resolved using the operator's version catalog.
+	// A minor version such as "1.27" resolves to the latest patch release
+	KubernetesVersion string `json:"kubernetesVersion"`
+
+	// VclusterChartVersion overrides the default vcluster chart
+	// version of the cluster, and is applied right away
+	VclusterChartVersion string `json:"vclusterChartVersion,omitempty"`
+
+	// ValuesOverrides are merged over the values of the vcluster
+	// release, after the VclusterValues of the cluster. ConfigMaps
+	// are read from the namespace of the Project
+	ValuesOverrides []ValuesSource `json:"valuesOverrides,omitempty"`
+
+	// Distro is the Kubernetes distribution backing the vcluster
+	// +kubebuilder:validation:Enum=k3s;k0s;k8s
+	// +kubebuilder:default=k3s
+	Distro string `json:"distro,omitempty"`
+
+	Resources   Resources             `json:"resources,omitempty"`
+	IngressHost string                `json:"ingressHost,omitempty"`
+	Users       []ProjectUser         `json:"users,omitempty"`
+	Crossplane  ProjectCrossplaneSpec `json:"crossplane,omitempty"`
 
 	Addons []ProjectAddonSpec `json:"addons,omitempty"`
+
+	// ClusterRef is the Cluster providing the OIDC, ingress and agent
+	// configuration for this project. When unset, the operator's
+	// default cluster is used
+	ClusterRef *ClusterReference `json:"clusterRef,omitempty"`
+
+	// Network configures the isolation of the project namespace
+	Network *ProjectNetworkSpec `json:"network,omitempty"`
+}
+
+// ProjectNetworkSpec extends the default network isolation of a project,
+// which only allows traffic within the project namespace, from the
+// ingress controller and the agent, and to DNS and the API server
+type ProjectNetworkSpec struct {
+	// Egress are additional egress rules for the project's
+	// workloads, such as access to external services
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
+
+	// Peers are the slugs of projects this project may exchange traffic
+	// with. Peering must be declared by both projects
+	Peers []string `json:"peers,omitempty"`
+}
+
+type ClusterReference struct {
+	// Name is the name of the Cluster
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the Cluster. Defaults to the
+	// namespace of the Project
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// LastActivityAnnotation records the last time a project was used, as an
+// RFC3339 timestamp. Projects with an IdleTimeout hibernate when it's older
+// than the timeout, and wake up as soon as it's updated
+const LastActivityAnnotation = "launchboxhq.io/last-activity"
+
+type ProjectHibernationSpec struct {
+	// Schedules are the periods during which the project sleeps
+	Schedules []HibernationSchedule `json:"schedules,omitempty"`
+
+	// IdleTimeout hibernates the project when its last activity
+	// is older than the timeout
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+}
+
+type HibernationSchedule struct {
+	// Sleep is the cron schedule pausing the project, such as "0 20 * * *"
+	Sleep string `json:"sleep"`
+
+	// Wake is the cron schedule resuming the project, such as "0 8 * * 1-5"
+	Wake string `json:"wake"`
+
+	// TimeZone is the IANA time zone of the schedules. Defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
 type ProjectAddonSpec struct {
@@ -55,30 +134,244 @@ type ProjectCrossplaneSpec struct {
 type AddonSubscription struct {
 }
 
+// Resources sizes the vcluster control plane, and bounds the workloads
+// synced into the project namespace with a ResourceQuota and LimitRange
 type Resources struct {
+	// Cpu, Memory (in MiB) and Disk (in GiB) are allocated to the vcluster
+	// control plane. They're added to the matching cpu, memory and storage
+	// quotas, so the control plane doesn't consume the workloads' allowance
 	Cpu    int32 `json:"cpu,omitempty"`
 	Memory int32 `json:"memory,omitempty"`
 	Disk   int32 `json:"disk,omitempty"`
+
+	// Requests caps the total requests of the workloads in the
+	// project namespace, such as cpu, memory or storage
+	Requests v1.ResourceList `json:"requests,omitempty"`
+
+	// Limits caps the total limits of the workloads in the project
+	// namespace. Containers without limits are rejected, unless
+	// DefaultLimits are set
+	Limits v1.ResourceList `json:"limits,omitempty"`
+
+	// Objects caps the number of objects in the project namespace, keyed
+	// by resource, such as pods, services or count/deployments.apps
+	Objects map[string]int64 `json:"objects,omitempty"`
+
+	// StorageClasses caps the storage requested from each storage class
+	StorageClasses []StorageClassQuota `json:"storageClasses,omitempty"`
+
+	// DefaultRequests are applied to containers without requests
+	DefaultRequests v1.ResourceList `json:"defaultRequests,omitempty"`
+
+	// DefaultLimits are applied to containers without limits
+	DefaultLimits v1.ResourceList `json:"defaultLimits,omitempty"`
+}
+
+type StorageClassQuota struct {
+	// Name is the name of the StorageClass
+	Name string `json:"name"`
+
+	// Storage caps the storage requested by claims of the class
+	Storage *resource.Quantity `json:"storage,omitempty"`
+
+	// PersistentVolumeClaims caps the number of claims of the class
+	PersistentVolumeClaims *int64 `json:"persistentVolumeClaims,omitempty"`
 }
 
+// ProjectUser grants a user or OIDC group access to the project's
+// vcluster. Exactly one of Email or Group should be set
 type ProjectUser struct {
-	Email       string `json:"email"`
+	// Email is the OIDC username of the user. Exactly one
+	// of Email and Group is set
+	Email string `json:"email,omitempty"`
+
+	// Group is an OIDC group, taken from the groups claim
+	Group string `json:"group,omitempty"`
+
+	// ClusterRole is the ClusterRole bound to the user inside the
+	// vcluster, such as cluster-admin, admin, edit, view or a custom role
 	ClusterRole string `json:"clusterRole"`
 }
 
+// ProjectPhase is a high level summary of where a Project is in its lifecycle
+// +kubebuilder:validation:Enum=Pending;Provisioning;Ready;Paused;Degraded;Deleting;Failed
+type ProjectPhase string
+
+const (
+	// ProjectPhasePending means the project has not been reconciled yet
+	ProjectPhasePending ProjectPhase = "Pending"
+	// ProjectPhaseProvisioning means the vcluster and its dependencies are being created
+	ProjectPhaseProvisioning ProjectPhase = "Provisioning"
+	// ProjectPhaseReady means every provisioning step has completed
+	ProjectPhaseReady ProjectPhase = "Ready"
+	// ProjectPhasePaused means the vcluster has been scaled down
+	ProjectPhasePaused ProjectPhase = "Paused"
+	// ProjectPhaseDegraded means a previously ready project is failing to reconcile
+	ProjectPhaseDegraded ProjectPhase = "Degraded"
+	// ProjectPhaseDeleting means the project is being torn down
+	ProjectPhaseDeleting ProjectPhase = "Deleting"
+	// ProjectPhaseFailed means the project failed in a way retrying won't fix,
+	// such as a failed vcluster release or an unsupported version
+	ProjectPhaseFailed ProjectPhase = "Failed"
+)
+
+// Condition types reported in ProjectStatus.Conditions
+const (
+	ProjectReady            = "Ready"
+	ProjectNamespaceReady   = "NamespaceReady"
+	ProjectHelmReleaseReady = "HelmReleaseReady"
+	ProjectKubeconfigReady  = "KubeconfigReady"
+	ProjectProvidersReady   = "ProvidersReady"
+	ProjectAddonsReady      = "AddonsReady"
+	ProjectPaused           = "Paused"
+	ProjectQuotaReady       = "QuotaReady"
+	ProjectNetworkReady     = "NetworkReady"
+
+	// ProjectHibernating is true while the project is
+	// paused by its hibernation settings
+	ProjectHibernating = "Hibernating"
+
+	// ProjectUpgrading is true while the vcluster is moving
+	// to a new Kubernetes version
+	ProjectUpgrading = "Upgrading"
+
+	// ProjectUpgradeBlocked is true when the requested Kubernetes
+	// version is a downgrade, or skips a minor version
+	ProjectUpgradeBlocked = "UpgradeBlocked"
+
+	// ProjectChartUpgrading is true while the vcluster is moving
+	// to a new default chart version of the cluster
+	ProjectChartUpgrading = "ChartUpgrading"
+
+	// ProjectUnsupportedVersion is true when the KubernetesVersion
+	// of the project isn't available in the version catalog
+	ProjectUnsupportedVersion = "UnsupportedVersion"
+)
+
 // ProjectStatus defines the observed state of Project
 type ProjectStatus struct {
-	Status        string                         `json:"status,omitempty"`
+	// Phase summarizes the conditions of the project
+	Phase ProjectPhase `json:"phase,omitempty"`
+
+	// Status is the phase in lower case, or provisioned once the
+	// project is ready.
+	//
+	// Deprecated: use Phase instead
+	Status string `json:"status,omitempty"`
+
+	// ObservedGeneration is the most recent generation reconciled by the operator
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// KubernetesVersion is the version running in the vcluster
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+
+	// RoleBindings are the ClusterRoleBindings of project users in the
+	// vcluster, once stale bindings have been removed
+	RoleBindings []string `json:"roleBindings,omitempty"`
+
+	// PreviousKubernetesVersion is the version the vcluster
+	// ran before its last completed upgrade
+	PreviousKubernetesVersion string `json:"previousKubernetesVersion,omitempty"`
+
+	// Quota reports the limits and usage of the project's ResourceQuota
+	Quota *ProjectQuotaStatus `json:"quota,omitempty"`
+
+	// VclusterChartVersion is the version of the installed vcluster chart
+	VclusterChartVersion string `json:"vclusterChartVersion,omitempty"`
+
+	// Pause records the state of the vcluster before it was paused
+	Pause *ProjectPauseStatus `json:"pause,omitempty"`
+
+	// Hibernation reports the state of the hibernation schedules
+	Hibernation *ProjectHibernationStatus `json:"hibernation,omitempty"`
+
+	// Upgrade tracks an in-progress Kubernetes version upgrade
+	Upgrade *ProjectUpgradeStatus `json:"upgrade,omitempty"`
+
+	// ValuesHash is the hash of the chart and rendered values of
+	// the last successful vcluster install or upgrade
+	ValuesHash string `json:"valuesHash,omitempty"`
+
+	Conditions    []metav1.Condition             `json:"conditions,omitempty"`
 	CaCertificate string                         `json:"caCertificate,omitempty"`
 	Addons        map[string]*ProjectAddonStatus `json:"addons,omitempty"`
 }
 
+type ProjectQuotaStatus struct {
+	Hard v1.ResourceList `json:"hard,omitempty"`
+	Used v1.ResourceList `json:"used,omitempty"`
+}
+
+type ProjectPauseStatus struct {
+	// PausedAt is when the project was paused
+	PausedAt metav1.Time `json:"pausedAt"`
+
+	// PreviousReplicas is the number of vcluster replicas
+	// restored when the project is resumed
+	PreviousReplicas int32 `json:"previousReplicas"`
+
+	// Drained is true once every synced workload has been evicted
+	Drained bool `json:"drained,omitempty"`
+}
+
+type ProjectHibernationStatus struct {
+	// Hibernating is true while the project is paused by its hibernation settings
+	Hibernating bool `json:"hibernating"`
+
+	// Reason is either Schedule or IdleTimeout while hibernating
+	Reason string `json:"reason,omitempty"`
+
+	// LastTransitionTime is when the project last went to sleep or woke up
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// NextTransitionTime is when the hibernation state will next be re-evaluated
+	NextTransitionTime *metav1.Time `json:"nextTransitionTime,omitempty"`
+}
+
+// ProjectUpgradePhase is the step an upgrade is in
+type ProjectUpgradePhase string
+
+const (
+	// ProjectUpgradeSnapshotting waits for the data volume snapshot to be ready
+	ProjectUpgradeSnapshotting ProjectUpgradePhase = "Snapshotting"
+	// ProjectUpgradeRollingOut waits for the vcluster to run the new version
+	ProjectUpgradeRollingOut ProjectUpgradePhase = "RollingOut"
+)
+
+type ProjectUpgradeStatus struct {
+	FromVersion string              `json:"fromVersion"`
+	ToVersion   string              `json:"toVersion"`
+	Phase       ProjectUpgradePhase `json:"phase"`
+	StartedAt   metav1.Time         `json:"startedAt"`
+
+	// Snapshot is the VolumeSnapshot of the vcluster data
+	// volume taken before upgrading
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
 type ProjectAddonStatus struct {
 	Conditions []metav1.Condition `json:"conditions"`
+
+	// Claim is the claim created for the addon, which is deleted with
+	// the project even if the addon was removed from the spec
+	Claim *ProjectAddonClaimReference `json:"claim,omitempty"`
+}
+
+// ProjectAddonClaimReference locates the claim created for an addon
+type ProjectAddonClaimReference struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Name     string `json:"name"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Slug",type=string,JSONPath=`.spec.slug`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.kubernetesVersion`
+//+kubebuilder:printcolumn:name="Chart",type=string,JSONPath=`.status.vclusterChartVersion`,priority=1
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Project is the Schema for the projects API
 type Project struct {
@@ -115,7 +408,15 @@ func (p *Project) GetAddonStatus(identifier string) *ProjectAddonStatus {
 		return status
 	}
 
+	if p.Status.Addons == nil {
+		p.Status.Addons = map[string]*ProjectAddonStatus{}
+	}
+
 	status := &ProjectAddonStatus{Conditions: []metav1.Condition{}}
 	p.Status.Addons[identifier] = status
 	return status
 }
+
+func (p *Project) GetConditions() []metav1.Condition {
+	return p.Status.Conditions
+}
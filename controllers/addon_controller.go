@@ -18,17 +18,18 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
-	corev1 "k8s.io/api/core/v1"
+	"github.com/launchboxio/operator/internal/registry"
+	addonscope "github.com/launchboxio/operator/internal/scope/addon"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
 )
@@ -36,12 +37,21 @@ import (
 // AddonReconciler reconciles a Addon object
 type AddonReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme    *runtime.Scheme
+	TagLister registry.TagLister
+
+	// APIReader reads package pull secrets from PackagePullSecretNamespace,
+	// the namespace Crossplane runs in, without caching secrets
+	APIReader                  client.Reader
+	PackagePullSecretNamespace string
 }
 
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=addons,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=addons/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=addons/finalizers,verbs=update
+//+kubebuilder:rbac:groups=pkg.crossplane.io,resources=configurations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=pkg.crossplane.io,resources=configurationrevisions,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=,resources=secrets,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -65,85 +75,44 @@ func (r *AddonReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{}, err
 	}
 
-	addonConfiguration := &crossplanev1.Configuration{}
-	if err := r.Get(ctx, req.NamespacedName, addonConfiguration); err != nil {
-		if apierrors.IsNotFound(err) {
-			// Create the configuration
-			c := r.configurationForAddon(addon)
-			if err := r.Create(ctx, c); err != nil {
-				logger.Error(err, "Failed creating addon configuration")
-				return ctrl.Result{}, err
-			}
-			return ctrl.Result{Requeue: true}, nil
-		}
-		logger.Error(err, "Failed to get Configuration resource")
-		return ctrl.Result{}, err
-	}
+	addonScope := addonscope.Scope{
+		Addon:     addon,
+		Logger:    logger,
+		Client:    r.Client,
+		Scheme:    r.Scheme,
+		TagLister: r.TagLister,
 
-	// TODO: Handle updates to the spec
-	hasChanges := false
-	packageName := fmt.Sprintf("%s:%s", addon.Spec.OciRegistry, addon.Spec.OciVersion)
-	if addonConfiguration.Spec.Package != packageName {
-		addonConfiguration.Spec.Package = packageName
-		hasChanges = true
+		APIReader:                  r.APIReader,
+		PackagePullSecretNamespace: r.PackagePullSecretNamespace,
 	}
-
-	if hasChanges {
-		err := r.Update(ctx, addonConfiguration)
-		if err != nil {
-			logger.Error(err, "Failed updating configuration")
-			return ctrl.Result{}, err
-		}
-		logger.Info("Configuration updated")
-	}
-
-	// TODO: Update Addon status
-	meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
-		Type:    "Ready",
-		Status:  metav1.ConditionTrue,
-		Reason:  "Installed",
-		Message: fmt.Sprintf("Crossplane addon has been installed"),
-	})
-	err := r.Client.Status().Update(ctx, addon)
-	return ctrl.Result{}, err
+	return addonScope.Reconcile(ctx, req)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AddonReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1alpha1.Addon{}).
+		Owns(&crossplanev1.Configuration{}).
+		Watches(
+			&crossplanev1.ConfigurationRevision{},
+			handler.EnqueueRequestsFromMapFunc(r.addonForRevision),
+		).
 		Complete(r)
 }
 
-func (r *AddonReconciler) configurationForAddon(addon *corev1alpha1.Addon) *crossplanev1.Configuration {
-	pullPolicy := corev1.PullAlways
-	if addon.Spec.PullPolicy == "IfNotPresent" {
-		pullPolicy = corev1.PullIfNotPresent
-	} else if addon.Spec.PullPolicy == "Never" {
-		pullPolicy = corev1.PullNever
-	}
-
-	activationPolicy := crossplanev1.AutomaticActivation
-	if addon.Spec.ActivationPolicy == "Manual" {
-		activationPolicy = crossplanev1.ManualActivation
+// addonForRevision maps a ConfigurationRevision to the Addon owning its
+// Configuration, so that revision health reaches the Addon status
+func (r *AddonReconciler) addonForRevision(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[crossplanev1.LabelParentPackage]
+	if name == "" {
+		return nil
 	}
 
-	revisionHistoryLimit := int64(10)
-
-	c := &crossplanev1.Configuration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: addon.Name,
-		},
-		Spec: crossplanev1.ConfigurationSpec{
-			PackageSpec: crossplanev1.PackageSpec{
-				Package:                  fmt.Sprintf("%s:%s", addon.Spec.OciRegistry, addon.Spec.OciVersion),
-				PackagePullPolicy:        &pullPolicy,
-				RevisionActivationPolicy: &activationPolicy,
-				RevisionHistoryLimit:     &revisionHistoryLimit,
-			},
-		},
+	// Configurations share the name of their Addon, but
+	// may also have been created outside of the operator
+	addon := &corev1alpha1.Addon{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, addon); err != nil {
+		return nil
 	}
-
-	ctrl.SetControllerReference(addon, c, r.Scheme)
-	return c
+	return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(addon)}}
 }
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+)
+
+func TestAddonForRevision(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1alpha1.AddToScheme(scheme)
+	_ = crossplanev1.AddToScheme(scheme)
+
+	addon := &corev1alpha1.Addon{ObjectMeta: metav1.ObjectMeta{Name: "postgres"}}
+	r := &AddonReconciler{
+		Client: fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(addon).Build(),
+		Scheme: scheme,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		labels   map[string]string
+		expected int
+	}{
+		{"addon configuration", map[string]string{crossplanev1.LabelParentPackage: "postgres"}, 1},
+		{"unmanaged configuration", map[string]string{crossplanev1.LabelParentPackage: "platform"}, 0},
+		{"unlabelled revision", nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			revision := &crossplanev1.ConfigurationRevision{
+				ObjectMeta: metav1.ObjectMeta{Name: "postgres-abc123", Labels: tc.labels},
+			}
+			requests := r.addonForRevision(context.TODO(), revision)
+			if len(requests) != tc.expected {
+				t.Fatalf("expected %d requests, got %v", tc.expected, requests)
+			}
+			if tc.expected == 1 && requests[0].Name != "postgres" {
+				t.Fatalf("expected a request for the postgres addon, got %v", requests[0])
+			}
+		})
+	}
+}
@@ -19,23 +19,51 @@ package controllers
 import (
 	"context"
 	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/helm"
 	clusterscope "github.com/launchboxio/operator/internal/scope/cluster"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
 )
 
 // ClusterReconciler reconciles a Cluster object
 type ClusterReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// DefaultCluster is the Cluster used by projects
+	// that don't specify a ClusterRef
+	DefaultCluster types.NamespacedName
+
+	// Recorder emits events about the agent lifecycle
+	Recorder record.EventRecorder
+
+	// HelmClientFactory provides the Helm client installing the agent
+	HelmClientFactory helm.ClientFactory
+
+	// APIReader reads from the API server, bypassing the cache
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=clusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=clusters/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core.launchboxhq.io,resources=projects,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+//+kubebuilder:rbac:groups=,resources=secrets,verbs=deletecollection
+//+kubebuilder:rbac:groups=,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -61,9 +89,24 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	// Projects are looked up through the index registered by the
+	// ProjectReconciler, which maps projects to their cluster
+	projects := &v1alpha1.ProjectList{}
+	if err := r.List(ctx, projects, client.MatchingFields{
+		projectClusterRefField: req.NamespacedName.String(),
+	}); err != nil {
+		logger.Error(err, "Failed listing cluster projects")
+		return ctrl.Result{}, err
+	}
+
 	clusterScope := clusterscope.Scope{
-		Cluster: cluster,
-		Client:  r.Client,
+		Cluster:           cluster,
+		Logger:            logger,
+		Client:            r.Client,
+		Projects:          projects.Items,
+		Recorder:          r.Recorder,
+		HelmClientFactory: r.HelmClientFactory,
+		APIReader:         r.APIReader,
 	}
 
 	return clusterScope.Reconcile(ctx, req)
@@ -74,5 +117,64 @@ func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		// Uncomment the following line adding a pointer to an instance of the controlled resource as an argument
 		For(&v1alpha1.Cluster{}).
+		Watches(
+			&v1alpha1.Project{},
+			handler.EnqueueRequestsFromMapFunc(r.deletedClusterForProject),
+		).
+		Watches(
+			&appsv1.Deployment{},
+			handler.EnqueueRequestsFromMapFunc(r.clusterForAgent),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isAgentDeployment)),
+		).
+		Watches(
+			&coordinationv1.Lease{},
+			handler.EnqueueRequestsFromMapFunc(r.clusterForAgent),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isAgentLease)),
+		).
 		Complete(r)
 }
+
+// clusterForAgent maps an agent Deployment or heartbeat lease to the
+// Cluster whose agent release it belongs to. Deployments are matched
+// by their instance label, and leases by their name
+func (r *ClusterReconciler) clusterForAgent(ctx context.Context, obj client.Object) []reconcile.Request {
+	releaseName := obj.GetName()
+	if _, ok := obj.(*appsv1.Deployment); ok {
+		releaseName = obj.GetLabels()["app.kubernetes.io/instance"]
+	}
+
+	clusters := &v1alpha1.ClusterList{}
+	if err := r.List(ctx, clusters); err != nil {
+		log.FromContext(ctx).Error(err, "Failed listing clusters")
+		return nil
+	}
+	for _, cluster := range clusters.Items {
+		if clusterscope.AgentReleaseName(&cluster) == releaseName {
+			return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(&cluster)}}
+		}
+	}
+	return nil
+}
+
+// deletedClusterForProject maps a Project to its Cluster while the
+// cluster is being deleted, so that deletion resumes once the last
+// project referencing it is gone
+func (r *ClusterReconciler) deletedClusterForProject(ctx context.Context, obj client.Object) []reconcile.Request {
+	key := clusterKeyForProject(obj.(*v1alpha1.Project), r.DefaultCluster)
+	cluster := &v1alpha1.Cluster{}
+	if err := r.Get(ctx, key, cluster); err != nil || cluster.GetDeletionTimestamp() == nil {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: key}}
+}
+
+// isAgentDeployment matches the Deployments of agent releases
+func isAgentDeployment(obj client.Object) bool {
+	return obj.GetNamespace() == clusterscope.AgentNamespace &&
+		strings.HasPrefix(obj.GetLabels()["app.kubernetes.io/instance"], "agent-")
+}
+
+// isAgentLease matches the leases agents renew as their heartbeat
+func isAgentLease(obj client.Object) bool {
+	return obj.GetNamespace() == clusterscope.AgentNamespace && strings.HasPrefix(obj.GetName(), "agent-")
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	clusterscope "github.com/launchboxio/operator/internal/scope/cluster"
+)
+
+func TestClusterForAgent(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1alpha1.AddToScheme(scheme)
+
+	production := &corev1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "production", Namespace: "lbx-system"}}
+	staging := &corev1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "lbx-system"}}
+	r := &ClusterReconciler{
+		Client: fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(production, staging).Build(),
+		Scheme: scheme,
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      "agent",
+		Namespace: clusterscope.AgentNamespace,
+		Labels:    map[string]string{"app.kubernetes.io/instance": clusterscope.AgentReleaseName(staging)},
+	}}
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:      clusterscope.AgentReleaseName(production),
+		Namespace: clusterscope.AgentNamespace,
+	}}
+	unknown := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:      "agent-lbx-system-removed",
+		Namespace: clusterscope.AgentNamespace,
+	}}
+
+	for _, tc := range []struct {
+		name     string
+		obj      client.Object
+		expected string
+	}{
+		{"agent deployment", deployment, "staging"},
+		{"agent lease", lease, "production"},
+		{"lease of a removed cluster", unknown, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := r.clusterForAgent(context.TODO(), tc.obj)
+			if tc.expected == "" {
+				if len(requests) != 0 {
+					t.Fatalf("expected no requests, got %v", requests)
+				}
+				return
+			}
+			if len(requests) != 1 || requests[0].Name != tc.expected {
+				t.Fatalf("expected a request for the %s cluster, got %v", tc.expected, requests)
+			}
+		})
+	}
+}
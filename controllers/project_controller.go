@@ -19,10 +19,16 @@ package controllers
 import (
 	"context"
 	"errors"
+	"github.com/launchboxio/operator/internal/helm"
 	projectscope "github.com/launchboxio/operator/internal/scope/project"
+	"github.com/launchboxio/operator/internal/versions"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
@@ -31,23 +37,67 @@ import (
 	"k8s.io/client-go/util/homedir"
 	"os"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"time"
 
 	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
 )
 
+const (
+	projectSlugField       = ".spec.slug"
+	projectClusterRefField = ".spec.clusterRef"
+
+	// projectReferencesField and clusterReferencesField index the
+	// ConfigMaps and Secrets read when rendering the vcluster release
+	projectReferencesField = ".spec.valuesOverrides.configMapKeyRef"
+	clusterReferencesField = ".spec.references"
+)
+
 // ProjectReconciler reconciles a Project object
 type ProjectReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// DefaultCluster is the Cluster used by projects
+	// that don't specify a ClusterRef
+	DefaultCluster types.NamespacedName
+
+	// VersionCatalog is the ConfigMap listing the available
+	// Kubernetes versions. The built-in catalog is used if it
+	// doesn't exist
+	VersionCatalog types.NamespacedName
+
+	// HelmClientFactory provides the Helm clients
+	// managing the vcluster releases
+	HelmClientFactory helm.ClientFactory
+
+	// APIReader reads from the API server, bypassing the cache
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=projects,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=projects/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.launchboxhq.io,resources=projects/finalizers,verbs=update
-//+kubebuilder:rbac:groups=,resources=namespaces,verbs=list;get;create;update;patch;delete
+//+kubebuilder:rbac:groups=,resources=namespaces,verbs=list;get;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=,resources=secrets,verbs=list;get;watch
+//+kubebuilder:rbac:groups=,resources=configmaps,verbs=list;get;watch
+//+kubebuilder:rbac:groups=,resources=resourcequotas;limitranges,verbs=list;get;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=,resources=endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=list;get;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=,resources=pods,verbs=list;get
+//+kubebuilder:rbac:groups=,resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=list;get;watch;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=list;get;watch
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;create
+//+kubebuilder:rbac:groups=helm.crossplane.io;kubernetes.crossplane.io,resources=providerconfigs,verbs=get;list;create;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -66,7 +116,6 @@ func (r *ProjectReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	project := &corev1alpha1.Project{}
 	err := r.Get(ctx, req.NamespacedName, project)
 	if err != nil {
-		// TODO: Operator is not deleting projects / namespaces as expected
 		if apierrors.IsNotFound(err) {
 			logger.Info("Resource not found, must be deleted")
 			return ctrl.Result{}, nil
@@ -75,49 +124,394 @@ func (r *ProjectReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	projectLogger := logger.WithValues("project", project.Spec.Slug)
+
+	dynClient, err := r.LoadDynamicClient()
+	if err != nil {
+		projectLogger.Error(err, "Failed loading dynamic client")
+		return ctrl.Result{}, err
+	}
+
+	// Deletion doesn't depend on the cluster configuration, so
+	// it's handled before waiting on the cluster to be ready
+	if project.GetDeletionTimestamp() != nil {
+		projectScope := projectscope.Scope{
+			Project:           project,
+			Logger:            projectLogger,
+			Client:            r.Client,
+			DynamicClient:     dynClient,
+			HelmClientFactory: r.HelmClientFactory,
+		}
+		return projectScope.ReconcileDelete(ctx, req)
+	}
+
 	cluster := &corev1alpha1.Cluster{}
-	err = r.Get(ctx, types.NamespacedName{
-		Name:      "default",
-		Namespace: "lbx-system",
-	}, cluster)
+	err = r.Get(ctx, r.clusterForProject(project), cluster)
 	if err != nil {
-		logger.Error(err, "Failed looking up cluster configurations")
+		logger.Error(err, "Failed looking up cluster configurations", "cluster", r.clusterForProject(project))
 		// TODO: We should update the project status as well
 		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 	}
 
-	// Check conditions.Ready
-	if meta.IsStatusConditionFalse(cluster.GetConditions(), "Ready") {
+	// Projects are only provisioned once the agent is connected
+	if !meta.IsStatusConditionTrue(cluster.GetConditions(), corev1alpha1.ClusterReady) {
 		logger.Error(errors.New("Cluster not ready"), "Waiting for cluster to become ready")
 		return ctrl.Result{RequeueAfter: time.Second * 10}, err
 	}
 
-	projectLogger := logger.WithValues("project", project.Spec.Slug)
-
-	dynClient, err := r.LoadDynamicClient()
+	catalog, err := versions.Load(ctx, r.Client, r.VersionCatalog)
 	if err != nil {
-		projectLogger.Error(err, "Failed loading dynamic client")
+		projectLogger.Error(err, "Failed loading version catalog")
+		return ctrl.Result{}, err
+	}
+
+	clusterProjects := &corev1alpha1.ProjectList{}
+	if err := r.List(ctx, clusterProjects, client.MatchingFields{
+		projectClusterRefField: r.clusterForProject(project).String(),
+	}); err != nil {
+		projectLogger.Error(err, "Failed listing cluster projects")
 		return ctrl.Result{}, err
 	}
 
 	projectScope := projectscope.Scope{
-		Project:       project,
-		Logger:        projectLogger,
-		Client:        r.Client,
-		DynamicClient: dynClient,
-		Cluster:       cluster,
+		Project:           project,
+		Logger:            projectLogger,
+		Client:            r.Client,
+		DynamicClient:     dynClient,
+		Cluster:           cluster,
+		ClusterProjects:   clusterProjects.Items,
+		APIReader:         r.APIReader,
+		Catalog:           catalog,
+		HelmClientFactory: r.HelmClientFactory,
+		VclusterClient:    r.vclusterClient,
 	}
 	return projectScope.Reconcile(ctx, req)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ProjectReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1alpha1.Project{}, projectSlugField, func(obj client.Object) []string {
+		return []string{obj.(*corev1alpha1.Project).Spec.Slug}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1alpha1.Project{}, projectClusterRefField, func(obj client.Object) []string {
+		return []string{r.clusterForProject(obj.(*corev1alpha1.Project)).String()}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1alpha1.Project{}, projectReferencesField, projectReferences); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1alpha1.Cluster{}, clusterReferencesField, clusterReferences); err != nil {
+		return err
+	}
+
+	// The manager only caches the version catalog and Helm release Secrets,
+	// so the ConfigMaps and Secrets projects reference are watched through
+	// their metadata, in a cache of their own
+	referenceCache, err := cache.New(mgr.GetConfig(), cache.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return err
+	}
+	if err := mgr.Add(referenceCache); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1alpha1.Project{}).
-		Owns(&v1.Namespace{}).
+		// Annotation changes are watched for the last activity
+		// annotation, which wakes up hibernating projects
+		For(&corev1alpha1.Project{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		// Cluster status changes on every agent heartbeat, so only
+		// spec changes and the cluster becoming (un)ready are watched
+		Watches(
+			&corev1alpha1.Cluster{},
+			handler.EnqueueRequestsFromMapFunc(r.projectsForCluster),
+			builder.WithPredicates(predicate.Or(
+				predicate.GenerationChangedPredicate{},
+				clusterReadyChanged,
+			)),
+		).
+		Watches(
+			&v1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.allProjects),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				return client.ObjectKeyFromObject(obj) == r.VersionCatalog
+			})),
+		).
+		Watches(
+			&v1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.projectForNamespace),
+		).
+		// Every project allows egress to the API server endpoints
+		Watches(
+			&v1.Endpoints{},
+			handler.EnqueueRequestsFromMapFunc(r.allProjects),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isAPIServerEndpoints), apiServerEndpointsChanged),
+		).
+		Watches(
+			&appsv1.StatefulSet{},
+			handler.EnqueueRequestsFromMapFunc(r.projectForNamespacedObject),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isVclusterStatefulSet)),
+		).
+		Watches(
+			&v1.ResourceQuota{},
+			handler.EnqueueRequestsFromMapFunc(r.projectForNamespacedObject),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isProjectQuota), quotaChanged),
+		).
+		Watches(
+			&v1.LimitRange{},
+			handler.EnqueueRequestsFromMapFunc(r.projectForNamespacedObject),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isProjectQuota), quotaChanged),
+		).
+		Watches(
+			&networkingv1.NetworkPolicy{},
+			handler.EnqueueRequestsFromMapFunc(r.projectForNamespacedObject),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isManagedObject)),
+		).
+		Watches(
+			&v1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.projectForNamespacedObject),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isVclusterSecret)),
+		).
+		WatchesRawSource(
+			source.Kind(referenceCache, referenceMetadata("ConfigMap")),
+			handler.EnqueueRequestsFromMapFunc(r.projectsForReference("ConfigMap")),
+		).
+		WatchesRawSource(
+			source.Kind(referenceCache, referenceMetadata("Secret")),
+			handler.EnqueueRequestsFromMapFunc(r.projectsForReference("Secret")),
+		).
 		Complete(r)
 }
 
+// clusterForProject returns the Cluster referenced by a project,
+// falling back to the default cluster
+func (r *ProjectReconciler) clusterForProject(project *corev1alpha1.Project) types.NamespacedName {
+	return clusterKeyForProject(project, r.DefaultCluster)
+}
+
+// clusterKeyForProject returns the Cluster referenced by a project, or
+// defaultCluster when it doesn't reference one
+func clusterKeyForProject(project *corev1alpha1.Project, defaultCluster types.NamespacedName) types.NamespacedName {
+	ref := project.Spec.ClusterRef
+	if ref == nil || ref.Name == "" {
+		return defaultCluster
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = project.Namespace
+	}
+	return types.NamespacedName{Name: ref.Name, Namespace: namespace}
+}
+
+// projectsForCluster maps a Cluster to every Project referencing it, so
+// that configuration changes propagate to the projects immediately
+func (r *ProjectReconciler) projectsForCluster(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.projectsMatching(ctx, projectClusterRefField, client.ObjectKeyFromObject(obj).String())
+}
+
+// projectsForReference maps a ConfigMap or Secret of the given kind to
+// the projects reading it, directly or through their Cluster
+func (r *ProjectReconciler) projectsForReference(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		key := referenceKey(kind, obj.GetNamespace(), obj.GetName())
+		requests := r.projectsMatching(ctx, projectReferencesField, key)
+
+		clusters := &corev1alpha1.ClusterList{}
+		if err := r.List(ctx, clusters, client.MatchingFields{clusterReferencesField: key}); err != nil {
+			log.FromContext(ctx).Error(err, "Failed listing clusters", clusterReferencesField, key)
+			return requests
+		}
+		for _, cluster := range clusters.Items {
+			requests = append(requests, r.projectsForCluster(ctx, &cluster)...)
+		}
+		return requests
+	}
+}
+
+// referenceMetadata returns the metadata of the objects of a core kind
+func referenceMetadata(kind string) *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind(kind))
+	return obj
+}
+
+// referenceKey identifies a ConfigMap or Secret in the reference indexes
+func referenceKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// projectReferences indexes the ConfigMaps of the values overrides of a project
+func projectReferences(obj client.Object) []string {
+	project := obj.(*corev1alpha1.Project)
+	var keys []string
+	for _, source := range project.Spec.ValuesOverrides {
+		if source.ConfigMapKeyRef != nil {
+			keys = append(keys, referenceKey("ConfigMap", project.Namespace, source.ConfigMapKeyRef.Name))
+		}
+	}
+	return keys
+}
+
+// clusterReferences indexes the ConfigMaps of the vcluster values of a
+// cluster, and the ConfigMaps and Secrets of its vcluster chart source
+func clusterReferences(obj client.Object) []string {
+	cluster := obj.(*corev1alpha1.Cluster)
+	var keys []string
+	for _, source := range cluster.Spec.VclusterValues {
+		if source.ConfigMapKeyRef != nil {
+			keys = append(keys, referenceKey("ConfigMap", cluster.Namespace, source.ConfigMapKeyRef.Name))
+		}
+	}
+	if source := cluster.Spec.Charts.Vcluster; source != nil {
+		if repository := source.Repository; repository != nil && repository.AuthSecretRef != nil {
+			keys = append(keys, referenceKey("Secret", cluster.Namespace, repository.AuthSecretRef.Name))
+		}
+		if oci := source.OCI; oci != nil && oci.PullSecretRef != nil {
+			keys = append(keys, referenceKey("Secret", cluster.Namespace, oci.PullSecretRef.Name))
+		}
+		if tarball := source.Tarball; tarball != nil && tarball.ConfigMapRef != nil {
+			keys = append(keys, referenceKey("ConfigMap", cluster.Namespace, tarball.ConfigMapRef.Name))
+		}
+	}
+	return keys
+}
+
+// allProjects returns a request for every Project, used
+// when the version catalog changes
+func (r *ProjectReconciler) allProjects(ctx context.Context, obj client.Object) []reconcile.Request {
+	projects := &corev1alpha1.ProjectList{}
+	if err := r.List(ctx, projects); err != nil {
+		log.FromContext(ctx).Error(err, "Failed listing projects")
+		return nil
+	}
+	return requestsForProjects(projects)
+}
+
+// projectForNamespace maps a project namespace to its Project
+func (r *ProjectReconciler) projectForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.projectsMatching(ctx, projectSlugField, obj.GetName())
+}
+
+// projectForNamespacedObject maps a resource in a project namespace,
+// such as the vcluster StatefulSet or Helm release, to its Project
+func (r *ProjectReconciler) projectForNamespacedObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.projectsMatching(ctx, projectSlugField, obj.GetNamespace())
+}
+
+// projectsMatching returns a request for every Project with
+// the given value for an indexed field
+func (r *ProjectReconciler) projectsMatching(ctx context.Context, field string, value string) []reconcile.Request {
+	projects := &corev1alpha1.ProjectList{}
+	if err := r.List(ctx, projects, client.MatchingFields{field: value}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed listing projects", field, value)
+		return nil
+	}
+	return requestsForProjects(projects)
+}
+
+func requestsForProjects(projects *corev1alpha1.ProjectList) []reconcile.Request {
+	requests := make([]reconcile.Request, len(projects.Items))
+	for i, project := range projects.Items {
+		requests[i] = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&project)}
+	}
+	return requests
+}
+
+// clusterReadyChanged matches Cluster updates changing its Ready condition
+var clusterReadyChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldCluster, ok := e.ObjectOld.(*corev1alpha1.Cluster)
+		if !ok {
+			return false
+		}
+		newCluster, ok := e.ObjectNew.(*corev1alpha1.Cluster)
+		if !ok {
+			return false
+		}
+		return meta.IsStatusConditionTrue(oldCluster.Status.Conditions, corev1alpha1.ClusterReady) !=
+			meta.IsStatusConditionTrue(newCluster.Status.Conditions, corev1alpha1.ClusterReady)
+	},
+}
+
+// quotaChanged matches ResourceQuota and LimitRange updates changing their
+// spec or the quota usage, which is reported in the project status. Updates
+// of their metadata only, such as managed fields, are ignored
+var quotaChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		switch oldObj := e.ObjectOld.(type) {
+		case *v1.ResourceQuota:
+			newObj, ok := e.ObjectNew.(*v1.ResourceQuota)
+			return !ok || !equality.Semantic.DeepEqual(oldObj.Spec, newObj.Spec) ||
+				!equality.Semantic.DeepEqual(oldObj.Status, newObj.Status)
+		case *v1.LimitRange:
+			newObj, ok := e.ObjectNew.(*v1.LimitRange)
+			return !ok || !equality.Semantic.DeepEqual(oldObj.Spec, newObj.Spec)
+		}
+		return true
+	},
+}
+
+// isVclusterStatefulSet matches the StatefulSet of a vcluster release,
+// which shares its name with the project namespace
+func isVclusterStatefulSet(obj client.Object) bool {
+	return obj.GetName() == obj.GetNamespace()
+}
+
+// isAPIServerEndpoints matches the endpoints of the kubernetes service
+func isAPIServerEndpoints(obj client.Object) bool {
+	return obj.GetNamespace() == metav1.NamespaceDefault && obj.GetName() == "kubernetes"
+}
+
+// apiServerEndpointsChanged matches Endpoints updates changing their
+// addresses or ports, ignoring updates of their metadata only
+var apiServerEndpointsChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldEndpoints, ok := e.ObjectOld.(*v1.Endpoints)
+		if !ok {
+			return true
+		}
+		newEndpoints, ok := e.ObjectNew.(*v1.Endpoints)
+		return !ok || !equality.Semantic.DeepEqual(oldEndpoints.Subsets, newEndpoints.Subsets)
+	},
+}
+
+// isProjectQuota matches the ResourceQuota and LimitRange
+// the operator creates in each project namespace
+func isProjectQuota(obj client.Object) bool {
+	return obj.GetName() == projectscope.QuotaName
+}
+
+// isManagedObject matches objects created by the
+// operator in project namespaces, such as NetworkPolicies
+func isManagedObject(obj client.Object) bool {
+	return obj.GetLabels()["app.kubernetes.io/managed-by"] == "launchboxhq"
+}
+
+// isVclusterSecret matches the secrets Helm uses to store the vcluster
+// release. The vcluster kubeconfig secret isn't cached, and is read
+// until it's available instead
+func isVclusterSecret(obj client.Object) bool {
+	labels := obj.GetLabels()
+	return labels["owner"] == "helm" && labels["name"] == obj.GetNamespace()
+}
+
+// vclusterClient returns a client of a vcluster API from its kubeconfig
+func (r *ProjectReconciler) vclusterClient(kubeconfig []byte) (client.Client, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: r.Scheme})
+}
+
 func (r *ProjectReconciler) LoadDynamicClient() (*dynamic.DynamicClient, error) {
 	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
 		config, err := rest.InClusterConfig()
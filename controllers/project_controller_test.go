@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+)
+
+func testCluster(ready metav1.ConditionStatus) *corev1alpha1.Cluster {
+	cluster := &corev1alpha1.Cluster{}
+	cluster.Status.Conditions = []metav1.Condition{{Type: corev1alpha1.ClusterReady, Status: ready}}
+	return cluster
+}
+
+func TestClusterReadyChanged(t *testing.T) {
+	heartbeat := testCluster(metav1.ConditionTrue)
+	heartbeat.Status.Agent = &corev1alpha1.ClusterAgentStatus{LastHeartbeatTime: &metav1.MicroTime{}}
+	if clusterReadyChanged.Update(event.UpdateEvent{ObjectOld: testCluster(metav1.ConditionTrue), ObjectNew: heartbeat}) {
+		t.Fatalf("expected heartbeats not to enqueue projects")
+	}
+	if !clusterReadyChanged.Update(event.UpdateEvent{ObjectOld: testCluster(metav1.ConditionTrue), ObjectNew: testCluster(metav1.ConditionFalse)}) {
+		t.Fatalf("expected the cluster becoming unready to enqueue projects")
+	}
+}
+
+func TestQuotaChanged(t *testing.T) {
+	quota := &v1.ResourceQuota{Spec: v1.ResourceQuotaSpec{Hard: v1.ResourceList{v1.ResourcePods: resource.MustParse("50")}}}
+	relabelled := quota.DeepCopy()
+	relabelled.ResourceVersion = "2"
+	if quotaChanged.Update(event.UpdateEvent{ObjectOld: quota, ObjectNew: relabelled}) {
+		t.Fatalf("expected metadata updates not to enqueue the project")
+	}
+
+	used := quota.DeepCopy()
+	used.Status.Used = v1.ResourceList{v1.ResourcePods: resource.MustParse("3")}
+	if !quotaChanged.Update(event.UpdateEvent{ObjectOld: quota, ObjectNew: used}) {
+		t.Fatalf("expected usage updates to enqueue the project")
+	}
+
+	edited := quota.DeepCopy()
+	edited.Spec.Hard[v1.ResourcePods] = resource.MustParse("100")
+	if !quotaChanged.Update(event.UpdateEvent{ObjectOld: quota, ObjectNew: edited}) {
+		t.Fatalf("expected edits of the quota to enqueue the project")
+	}
+}
+
+func TestProjectsForReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1alpha1.AddToScheme(scheme)
+
+	defaultCluster := types.NamespacedName{Name: "default", Namespace: "lbx-system"}
+	cluster := &corev1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "lbx-system"},
+		Spec: corev1alpha1.ClusterSpec{
+			VclusterValues: []corev1alpha1.ValuesSource{{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: "cluster-values"},
+			}}},
+			Charts: corev1alpha1.ClusterChartsSpec{Vcluster: &corev1alpha1.ChartSource{
+				OCI: &corev1alpha1.OCIRegistrySource{PullSecretRef: &v1.LocalObjectReference{Name: "registry-credentials"}},
+			}},
+		},
+	}
+	withOverrides := &corev1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "production", Namespace: "team-a"},
+		Spec: corev1alpha1.ProjectSpec{ValuesOverrides: []corev1alpha1.ValuesSource{{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: "project-values"},
+		}}}},
+	}
+	withoutOverrides := &corev1alpha1.Project{ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "team-b"}}
+
+	r := &ProjectReconciler{Scheme: scheme, DefaultCluster: defaultCluster}
+	r.Client = fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(cluster, withOverrides, withoutOverrides).
+		WithIndex(&corev1alpha1.Project{}, projectClusterRefField, func(obj client.Object) []string {
+			return []string{r.clusterForProject(obj.(*corev1alpha1.Project)).String()}
+		}).
+		WithIndex(&corev1alpha1.Project{}, projectReferencesField, projectReferences).
+		WithIndex(&corev1alpha1.Cluster{}, clusterReferencesField, clusterReferences).
+		Build()
+
+	for _, tc := range []struct {
+		name     string
+		kind     string
+		obj      client.Object
+		expected int
+	}{
+		{"project values", "ConfigMap", &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "project-values", Namespace: "team-a"}}, 1},
+		{"cluster values", "ConfigMap", &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cluster-values", Namespace: "lbx-system"}}, 2},
+		{"chart pull secret", "Secret", &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry-credentials", Namespace: "lbx-system"}}, 2},
+		{"same name in another namespace", "ConfigMap", &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "project-values", Namespace: "team-b"}}, 0},
+		{"secret named like a values ConfigMap", "Secret", &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cluster-values", Namespace: "lbx-system"}}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := r.projectsForReference(tc.kind)(context.TODO(), tc.obj)
+			if len(requests) != tc.expected {
+				t.Fatalf("expected %d requests, got %v", tc.expected, requests)
+			}
+		})
+	}
+}
+
+func TestAPIServerEndpointsChanged(t *testing.T) {
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []v1.EndpointPort{{Name: "https", Port: 6443}},
+		}},
+	}
+	if !isAPIServerEndpoints(endpoints) {
+		t.Fatalf("expected the kubernetes endpoints to be matched")
+	}
+
+	relabelled := endpoints.DeepCopy()
+	relabelled.ResourceVersion = "2"
+	if apiServerEndpointsChanged.Update(event.UpdateEvent{ObjectOld: endpoints, ObjectNew: relabelled}) {
+		t.Fatalf("expected metadata updates not to enqueue projects")
+	}
+
+	moved := endpoints.DeepCopy()
+	moved.Subsets[0].Addresses[0].IP = "10.0.0.2"
+	if !apiServerEndpointsChanged.Update(event.UpdateEvent{ObjectOld: endpoints, ObjectNew: moved}) {
+		t.Fatalf("expected address changes to enqueue projects")
+	}
+}
@@ -0,0 +1,175 @@
+package charts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/helm"
+	"helm.sh/helm/v3/pkg/repo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+var (
+	// DefaultVclusterSource is used when the Cluster doesn't set one
+	DefaultVclusterSource = v1alpha1.ChartSource{
+		Repository: &v1alpha1.HelmRepositorySource{URL: "https://charts.loft.sh"},
+	}
+
+	// DefaultAgentSource is used when the Cluster doesn't set one
+	DefaultAgentSource = v1alpha1.ChartSource{
+		OCI: &v1alpha1.OCIRegistrySource{URL: "oci://ghcr.io/launchboxio/agent/helm"},
+	}
+
+	// CacheDir stores the registry credentials, CA certificates and
+	// chart archives read from Secrets and ConfigMaps. Files are named
+	// after their content, so changes are picked up as new files
+	CacheDir = filepath.Join(os.TempDir(), "launchbox")
+)
+
+// Chart is the location of a chart resolved from its source
+type Chart struct {
+	// Name is the chart name of the release, such as a repository
+	// chart, an OCI reference or the path of a chart archive
+	Name string
+
+	// Repository has to be added to the Helm client before installing
+	Repository *repo.Entry
+
+	// Options configure the Helm client pulling the chart
+	Options helm.Options
+
+	// Archive is true for chart archives, whose version is set
+	// by the archive instead of being chosen when installing
+	Archive bool
+}
+
+// Resolve locates a chart in a source. Secrets and ConfigMaps
+// referenced by the source are read from the given namespace
+func Resolve(ctx context.Context, c client.Reader, namespace string, source *v1alpha1.ChartSource, chart string) (*Chart, error) {
+	switch {
+	case source.Repository != nil:
+		return resolveRepository(ctx, c, namespace, source.Repository, chart)
+	case source.OCI != nil:
+		return resolveOCI(ctx, c, namespace, source.OCI, chart)
+	case source.Tarball != nil:
+		return resolveTarball(ctx, c, namespace, source.Tarball, chart)
+	}
+	return nil, fmt.Errorf("chart source for %s has no repository, oci or tarball set", chart)
+}
+
+func resolveRepository(ctx context.Context, c client.Reader, namespace string, source *v1alpha1.HelmRepositorySource, chart string) (*Chart, error) {
+	entry := &repo.Entry{
+		URL:                   source.URL,
+		InsecureSkipTLSverify: source.InsecureSkipTLSVerify,
+	}
+
+	if source.AuthSecretRef != nil {
+		secret := &v1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Name: source.AuthSecretRef.Name, Namespace: namespace}, secret); err != nil {
+			return nil, err
+		}
+		entry.Username = string(secret.Data["username"])
+		entry.Password = string(secret.Data["password"])
+		if ca, ok := secret.Data["ca.crt"]; ok {
+			path, err := writeCached(ca, ".crt")
+			if err != nil {
+				return nil, err
+			}
+			entry.CAFile = path
+		}
+	}
+
+	// Helm doesn't update repositories that already exist, so
+	// repositories are named after their settings
+	entry.Name = "launchbox-" + digest([]byte(fmt.Sprintf("%s|%s|%s|%s|%t",
+		entry.URL, entry.Username, entry.Password, entry.CAFile, entry.InsecureSkipTLSverify)))[:12]
+
+	return &Chart{Name: entry.Name + "/" + chart, Repository: entry}, nil
+}
+
+func resolveOCI(ctx context.Context, c client.Reader, namespace string, source *v1alpha1.OCIRegistrySource, chart string) (*Chart, error) {
+	resolved := &Chart{Name: strings.TrimSuffix(source.URL, "/") + "/" + chart}
+	if source.PullSecretRef == nil {
+		return resolved, nil
+	}
+
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: source.PullSecretRef.Name, Namespace: namespace}, secret); err != nil {
+		return nil, err
+	}
+	config, ok := secret.Data[v1.DockerConfigJsonKey]
+	if !ok {
+		return nil, fmt.Errorf("pull secret %s has no %s key", source.PullSecretRef.Name, v1.DockerConfigJsonKey)
+	}
+	path, err := writeCached(config, ".json")
+	if err != nil {
+		return nil, err
+	}
+	resolved.Options.RegistryConfig = path
+	return resolved, nil
+}
+
+func resolveTarball(ctx context.Context, c client.Reader, namespace string, source *v1alpha1.ChartTarballSource, chart string) (*Chart, error) {
+	archive := chart + ".tgz"
+
+	if source.ConfigMapRef == nil {
+		path := filepath.Join(source.Path, archive)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("chart archive for %s not found: %w", chart, err)
+		}
+		return &Chart{Name: path, Archive: true}, nil
+	}
+
+	configMap := &v1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Name: source.ConfigMapRef.Name, Namespace: namespace}, configMap); err != nil {
+		return nil, err
+	}
+	data, ok := configMap.BinaryData[archive]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s has no %s key", source.ConfigMapRef.Name, archive)
+	}
+	path, err := writeCached(data, ".tgz")
+	if err != nil {
+		return nil, err
+	}
+	return &Chart{Name: path, Archive: true}, nil
+}
+
+// writeCached writes content to the cache directory,
+// unless it was already written, and returns its path
+func writeCached(content []byte, extension string) (string, error) {
+	path := filepath.Join(CacheDir, digest(content)+extension)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(CacheDir, 0o700); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first, so that
+	// partially written files are never used
+	tmp, err := os.CreateTemp(CacheDir, "partial-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+func digest(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
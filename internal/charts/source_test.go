@@ -0,0 +1,96 @@
+package charts
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResolveRepository(t *testing.T) {
+	CacheDir = t.TempDir()
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror-auth", Namespace: "lbx-system"},
+		Data: map[string][]byte{
+			"username": []byte("launchbox"),
+			"password": []byte("secret"),
+			"ca.crt":   []byte("certificate"),
+		},
+	}).Build()
+
+	chart, err := Resolve(context.TODO(), c, "lbx-system", &v1alpha1.ChartSource{
+		Repository: &v1alpha1.HelmRepositorySource{
+			URL:           "https://charts.example.com",
+			AuthSecretRef: &v1.LocalObjectReference{Name: "mirror-auth"},
+		},
+	}, "vcluster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chart.Name != chart.Repository.Name+"/vcluster" {
+		t.Fatalf("expected the chart to be pulled from the repository, got %s", chart.Name)
+	}
+	if chart.Repository.Username != "launchbox" || chart.Repository.Password != "secret" {
+		t.Fatalf("expected repository credentials to be set")
+	}
+	if ca, err := os.ReadFile(chart.Repository.CAFile); err != nil || string(ca) != "certificate" {
+		t.Fatalf("expected the CA certificate to be written, got %q: %v", ca, err)
+	}
+}
+
+func TestResolveOCI(t *testing.T) {
+	CacheDir = t.TempDir()
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "lbx-system"},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}).Build()
+
+	chart, err := Resolve(context.TODO(), c, "lbx-system", &v1alpha1.ChartSource{
+		OCI: &v1alpha1.OCIRegistrySource{
+			URL:           "oci://registry.example.com/charts/",
+			PullSecretRef: &v1.LocalObjectReference{Name: "registry"},
+		},
+	}, "agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chart.Name != "oci://registry.example.com/charts/agent" {
+		t.Fatalf("unexpected chart name %s", chart.Name)
+	}
+	if !strings.HasPrefix(chart.Options.RegistryConfig, CacheDir) {
+		t.Fatalf("expected registry credentials to be written, got %q", chart.Options.RegistryConfig)
+	}
+}
+
+func TestResolveTarball(t *testing.T) {
+	CacheDir = t.TempDir()
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "charts", Namespace: "lbx-system"},
+		BinaryData: map[string][]byte{"vcluster.tgz": []byte("archive")},
+	}).Build()
+	source := &v1alpha1.ChartSource{
+		Tarball: &v1alpha1.ChartTarballSource{ConfigMapRef: &v1.LocalObjectReference{Name: "charts"}},
+	}
+
+	chart, err := Resolve(context.TODO(), c, "lbx-system", source, "vcluster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if archive, err := os.ReadFile(chart.Name); err != nil || string(archive) != "archive" {
+		t.Fatalf("expected the chart archive to be written, got %q: %v", archive, err)
+	}
+	if !chart.Archive {
+		t.Fatal("expected the chart to be reported as an archive")
+	}
+
+	if _, err := Resolve(context.TODO(), c, "lbx-system", source, "vcluster-k8s"); err == nil {
+		t.Fatal("expected an error for a missing chart archive")
+	}
+}
@@ -0,0 +1,58 @@
+// Package conditions holds the status helpers shared by the scopes:
+// setting conditions, writing a changed status, and identifying the
+// Helm releases the scopes install
+package conditions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	helmclient "github.com/mittwald/go-helm-client"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Set sets a condition, observed at the generation of its object
+func Set(conditions *[]metav1.Condition, generation int64, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
+
+func MarkTrue(conditions *[]metav1.Condition, generation int64, conditionType string, reason string, message string) {
+	Set(conditions, generation, conditionType, metav1.ConditionTrue, reason, message)
+}
+
+func MarkFalse(conditions *[]metav1.Condition, generation int64, conditionType string, reason string, message string) {
+	Set(conditions, generation, conditionType, metav1.ConditionFalse, reason, message)
+}
+
+func MarkUnknown(conditions *[]metav1.Condition, generation int64, conditionType string, reason string, message string) {
+	Set(conditions, generation, conditionType, metav1.ConditionUnknown, reason, message)
+}
+
+// PatchStatus writes the status of obj if it has changed from the
+// status the reconciliation started with. status points to the
+// current status of obj, and original to its copy
+func PatchStatus(ctx context.Context, c client.Client, obj client.Object, original interface{}, status interface{}) error {
+	if equality.Semantic.DeepEqual(original, status) {
+		return nil
+	}
+	return c.Status().Update(ctx, obj)
+}
+
+// ReleaseHash identifies the chart and values of a release, so that
+// unchanged releases aren't upgraded on every reconciliation
+func ReleaseHash(chartSpec *helmclient.ChartSpec) string {
+	hash := sha256.New()
+	hash.Write([]byte(chartSpec.ChartName))
+	hash.Write([]byte(chartSpec.Version))
+	hash.Write([]byte(chartSpec.ValuesYaml))
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,71 @@
+package conditions
+
+import (
+	"context"
+	"testing"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+)
+
+func TestMark(t *testing.T) {
+	var conditions []metav1.Condition
+	MarkFalse(&conditions, 2, "Ready", "Provisioning", "Project is being provisioned")
+	MarkTrue(&conditions, 3, "Ready", "Provisioned", "Project has been provisioned")
+	MarkUnknown(&conditions, 3, "Connected", "NoHeartbeat", "Agent hasn't sent a heartbeat yet")
+
+	ready := meta.FindStatusCondition(conditions, "Ready")
+	if len(conditions) != 2 || ready.Status != metav1.ConditionTrue || ready.ObservedGeneration != 3 {
+		t.Fatalf("unexpected conditions %+v", conditions)
+	}
+	if connected := meta.FindStatusCondition(conditions, "Connected"); connected.Status != metav1.ConditionUnknown {
+		t.Fatalf("expected the connection to be unknown, got %+v", connected)
+	}
+}
+
+func TestPatchStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(scheme)
+	cluster := &v1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "lbx-system"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).WithStatusSubresource(cluster).Build()
+
+	original := cluster.Status.DeepCopy()
+	if err := PatchStatus(context.TODO(), c, cluster, original, &cluster.Status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resourceVersion := cluster.ResourceVersion
+
+	MarkTrue(&cluster.Status.Conditions, cluster.Generation, v1alpha1.ClusterReady, "AgentDisabled", "Agent is disabled")
+	if err := PatchStatus(context.TODO(), c, cluster, original, &cluster.Status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cluster.ResourceVersion == resourceVersion {
+		t.Fatalf("expected the changed status to be written")
+	}
+
+	stored := &v1alpha1.Cluster{}
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(cluster), stored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(stored.Status.Conditions, v1alpha1.ClusterReady) {
+		t.Fatalf("expected the status to be persisted")
+	}
+}
+
+func TestReleaseHash(t *testing.T) {
+	spec := &helmclient.ChartSpec{ChartName: "vcluster", Version: "0.16.4", ValuesYaml: "sync: {}"}
+	if ReleaseHash(spec) != ReleaseHash(&helmclient.ChartSpec{ChartName: "vcluster", Version: "0.16.4", ValuesYaml: "sync: {}"}) {
+		t.Fatalf("expected identical releases to hash the same")
+	}
+	upgraded := *spec
+	upgraded.Version = "0.17.0"
+	if ReleaseHash(spec) == ReleaseHash(&upgraded) {
+		t.Fatalf("expected the chart version to change the hash")
+	}
+}
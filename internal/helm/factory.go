@@ -0,0 +1,105 @@
+package helm
+
+import (
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/client-go/rest"
+	"sync"
+	"time"
+)
+
+// repositoryRefreshInterval is how often the index of
+// a chart repository is downloaded again
+const repositoryRefreshInterval = 10 * time.Minute
+
+// ClientFactory returns Helm clients managing the releases of a namespace.
+// It's the HelmClientFactory injected into the reconcilers, so that the
+// scopes can be tested against an in-memory implementation
+type ClientFactory interface {
+	ForNamespace(namespace string, opts Options) (helmclient.Client, error)
+
+	// Evict drops the client of a namespace, once its
+	// releases are no longer managed
+	Evict(namespace string)
+}
+
+// Options configure the clients returned by a ClientFactory
+type Options struct {
+	// RegistryConfig is the path of the credentials
+	// used to pull charts from OCI registries
+	RegistryConfig string
+}
+
+// NewClientFactory returns a ClientFactory building clients from a rest
+// config. A client is cached for each namespace, and replaced when it's
+// requested with other options
+func NewClientFactory(config *rest.Config) ClientFactory {
+	return &clientFactory{
+		newClient: func(namespace string, opts Options) (helmclient.Client, error) {
+			return helmclient.NewClientFromRestConf(&helmclient.RestConfClientOptions{
+				RestConfig: config,
+				Options: &helmclient.Options{
+					Namespace:      namespace,
+					RegistryConfig: opts.RegistryConfig,
+				},
+			})
+		},
+		clients: map[string]*cachedClient{},
+	}
+}
+
+type clientFactory struct {
+	newClient func(namespace string, opts Options) (helmclient.Client, error)
+
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+func (f *clientFactory) ForNamespace(namespace string, opts Options) (helmclient.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if client, ok := f.clients[namespace]; ok && client.opts == opts {
+		return client, nil
+	}
+
+	client, err := f.newClient(namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &cachedClient{Client: client, opts: opts, repositories: map[repo.Entry]time.Time{}}
+	f.clients[namespace] = cached
+	return cached, nil
+}
+
+func (f *clientFactory) Evict(namespace string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.clients, namespace)
+}
+
+// cachedClient skips adding chart repositories that were added
+// recently, which would otherwise download the repository
+// index on every reconciliation
+type cachedClient struct {
+	helmclient.Client
+	opts Options
+
+	mu           sync.Mutex
+	repositories map[repo.Entry]time.Time
+}
+
+func (c *cachedClient) AddOrUpdateChartRepo(entry repo.Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if added, ok := c.repositories[entry]; ok && time.Since(added) < repositoryRefreshInterval {
+		return nil
+	}
+	if err := c.Client.AddOrUpdateChartRepo(entry); err != nil {
+		return err
+	}
+	c.repositories[entry] = time.Now()
+	return nil
+}
@@ -0,0 +1,75 @@
+package helm
+
+import (
+	"testing"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+type countingClient struct {
+	helmclient.Client
+	adds int
+}
+
+func (c *countingClient) AddOrUpdateChartRepo(entry repo.Entry) error {
+	c.adds++
+	return nil
+}
+
+func TestCachedClientSkipsRecentRepositories(t *testing.T) {
+	inner := &countingClient{}
+	client := &cachedClient{Client: inner, repositories: map[repo.Entry]time.Time{}}
+	loft := repo.Entry{Name: "loft-sh", URL: "https://charts.loft.sh"}
+
+	for i := 0; i < 3; i++ {
+		if err := client.AddOrUpdateChartRepo(loft); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if inner.adds != 1 {
+		t.Fatalf("expected the repository to be added once, got %d", inner.adds)
+	}
+
+	client.repositories[loft] = time.Now().Add(-repositoryRefreshInterval)
+	if err := client.AddOrUpdateChartRepo(loft); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inner.adds != 2 {
+		t.Fatalf("expected the repository to be refreshed, got %d adds", inner.adds)
+	}
+}
+
+func TestClientFactoryReplacesClients(t *testing.T) {
+	built := 0
+	factory := &clientFactory{
+		newClient: func(namespace string, opts Options) (helmclient.Client, error) {
+			built++
+			return &countingClient{}, nil
+		},
+		clients: map[string]*cachedClient{},
+	}
+	forNamespace := func(namespace string, opts Options) helmclient.Client {
+		client, err := factory.ForNamespace(namespace, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return client
+	}
+
+	client := forNamespace("testing", Options{})
+	if forNamespace("testing", Options{}) != client || built != 1 {
+		t.Fatalf("expected the client to be reused, built %d", built)
+	}
+
+	forNamespace("testing", Options{RegistryConfig: "/tmp/config.json"})
+	if len(factory.clients) != 1 || built != 2 {
+		t.Fatalf("expected the client to be replaced, got %d clients", len(factory.clients))
+	}
+
+	factory.Evict("testing")
+	if len(factory.clients) != 0 {
+		t.Fatalf("expected the client to be evicted, got %d clients", len(factory.clients))
+	}
+}
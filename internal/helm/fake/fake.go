@@ -0,0 +1,180 @@
+// Package fake provides an in-memory Helm client, used to
+// test the install, upgrade and uninstall paths of the scopes
+package fake
+
+import (
+	"context"
+	"github.com/launchboxio/operator/internal/helm"
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"sigs.k8s.io/yaml"
+	"sync"
+)
+
+// ClientFactory returns a fake Client for each namespace
+type ClientFactory struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+
+	// Evicted records the namespaces evicted, whose
+	// clients are kept so tests can inspect them
+	Evicted []string
+}
+
+var _ helm.ClientFactory = &ClientFactory{}
+
+func NewClientFactory() *ClientFactory {
+	return &ClientFactory{clients: map[string]*Client{}}
+}
+
+// ForNamespace returns the fake client of a namespace. Options are
+// recorded on the client, which is shared across options
+func (f *ClientFactory) ForNamespace(namespace string, opts helm.Options) (helmclient.Client, error) {
+	client := f.Client(namespace)
+	client.Options = opts
+	return client, nil
+}
+
+func (f *ClientFactory) Evict(namespace string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Evicted = append(f.Evicted, namespace)
+}
+
+// Client returns the fake client of a namespace, so tests can
+// seed releases or inspect the operations performed
+func (f *ClientFactory) Client(namespace string) *Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	client, ok := f.clients[namespace]
+	if !ok {
+		client = NewClient(namespace)
+		f.clients[namespace] = client
+	}
+	return client
+}
+
+// Client stores releases in memory. Releases are deployed as soon as
+// they're installed or upgraded, unless InstallErr is set. Methods
+// not needed by the scopes panic through the nil embedded interface
+type Client struct {
+	helmclient.Client
+
+	Namespace    string
+	Options      helm.Options
+	Releases     map[string]*release.Release
+	Repositories map[string]repo.Entry
+
+	// InstallErr fails every install or upgrade
+	InstallErr error
+
+	// Installs, Uninstalls and LastInstall record the
+	// operations performed on the client
+	Installs    int
+	Uninstalls  int
+	LastInstall *helmclient.ChartSpec
+}
+
+func NewClient(namespace string) *Client {
+	return &Client{
+		Namespace:    namespace,
+		Releases:     map[string]*release.Release{},
+		Repositories: map[string]repo.Entry{},
+	}
+}
+
+func (c *Client) AddOrUpdateChartRepo(entry repo.Entry) error {
+	c.Repositories[entry.Name] = entry
+	return nil
+}
+
+func (c *Client) UpdateChartRepos() error {
+	return nil
+}
+
+func (c *Client) InstallOrUpgradeChart(ctx context.Context, spec *helmclient.ChartSpec, opts *helmclient.GenericHelmOptions) (*release.Release, error) {
+	c.Installs++
+	c.LastInstall = spec
+	if c.InstallErr != nil {
+		return nil, c.InstallErr
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(spec.ValuesYaml), &values); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if existing, ok := c.Releases[spec.ReleaseName]; ok {
+		version = existing.Version + 1
+	}
+	rel := &release.Release{
+		Name:      spec.ReleaseName,
+		Namespace: c.Namespace,
+		Version:   version,
+		Config:    values,
+		Chart: &chart.Chart{Metadata: &chart.Metadata{
+			Name:    spec.ChartName,
+			Version: spec.Version,
+		}},
+		Info: &release.Info{Status: release.StatusDeployed},
+	}
+	c.Releases[spec.ReleaseName] = rel
+	return rel, nil
+}
+
+func (c *Client) InstallChart(ctx context.Context, spec *helmclient.ChartSpec, opts *helmclient.GenericHelmOptions) (*release.Release, error) {
+	return c.InstallOrUpgradeChart(ctx, spec, opts)
+}
+
+func (c *Client) UpgradeChart(ctx context.Context, spec *helmclient.ChartSpec, opts *helmclient.GenericHelmOptions) (*release.Release, error) {
+	return c.InstallOrUpgradeChart(ctx, spec, opts)
+}
+
+func (c *Client) ListDeployedReleases() ([]*release.Release, error) {
+	return c.ListReleasesByStateMask(action.ListDeployed)
+}
+
+func (c *Client) ListReleasesByStateMask(states action.ListStates) ([]*release.Release, error) {
+	var releases []*release.Release
+	for _, rel := range c.Releases {
+		if states.FromName(rel.Info.Status.String())&states != 0 {
+			releases = append(releases, rel)
+		}
+	}
+	return releases, nil
+}
+
+func (c *Client) GetRelease(name string) (*release.Release, error) {
+	rel, ok := c.Releases[name]
+	if !ok {
+		return nil, driver.ErrReleaseNotFound
+	}
+	return rel, nil
+}
+
+func (c *Client) GetReleaseValues(name string, allValues bool) (map[string]interface{}, error) {
+	rel, err := c.GetRelease(name)
+	if err != nil {
+		return nil, err
+	}
+	return rel.Config, nil
+}
+
+func (c *Client) UninstallRelease(spec *helmclient.ChartSpec) error {
+	return c.UninstallReleaseByName(spec.ReleaseName)
+}
+
+func (c *Client) UninstallReleaseByName(name string) error {
+	if _, ok := c.Releases[name]; !ok {
+		return driver.ErrReleaseNotFound
+	}
+	c.Uninstalls++
+	delete(c.Releases, name)
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package fake provides a local OCI registry serving tag lists,
+// used to test addons following a version channel
+package fake
+
+import (
+	"encoding/json"
+	"github.com/launchboxio/operator/internal/registry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry serves the tag list API of the OCI distribution spec over
+// TLS. Repositories are unknown until their tags are set
+type Registry struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	tags     map[string][]string
+	requests int
+	username string
+	password string
+	delay    time.Duration
+}
+
+// NewRegistry starts a Registry, which is stopped with Close
+func NewRegistry() *Registry {
+	r := &Registry{tags: map[string][]string{}}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// Host returns the host:port repositories of the registry are prefixed with
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.server.URL, "https://")
+}
+
+// TagLister returns a TagLister trusting the registry certificate
+func (r *Registry) TagLister() registry.TagLister {
+	return registry.NewTagLister(registry.Options{HTTPClient: r.HTTPClient()})
+}
+
+// HTTPClient returns an HTTP client trusting the registry certificate
+func (r *Registry) HTTPClient() *http.Client {
+	return r.server.Client()
+}
+
+// SetCredentials requires basic authentication with
+// username and password to list tags
+func (r *Registry) SetCredentials(username, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.username = username
+	r.password = password
+}
+
+// SetDelay delays the responses of the registry
+func (r *Registry) SetDelay(delay time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.delay = delay
+}
+
+// SetTags replaces the tags of a repository, named without the registry host
+func (r *Registry) SetTags(repository string, tags ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags[repository] = tags
+}
+
+// Requests returns how many tag lists were requested
+func (r *Registry) Requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	repository, found := strings.CutSuffix(path, "/tags/list")
+	if req.Method != http.MethodGet || !found {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.mu.Lock()
+	r.requests++
+	tags, ok := r.tags[repository]
+	username, password, delay := r.username, r.password, r.delay
+	r.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-req.Context().Done():
+		return
+	}
+
+	if username != "" {
+		if u, p, found := req.BasicAuth(); !found || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": []map[string]string{{"code": "NAME_UNKNOWN", "message": "repository name not known to registry"}},
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": tags})
+}
@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	orasregistry "oras.land/oras-go/pkg/registry"
+	"oras.land/oras-go/pkg/registry/remote"
+	"oras.land/oras-go/pkg/registry/remote/auth"
+	"strings"
+	"time"
+)
+
+// DefaultRegistry is the registry Crossplane pulls packages
+// from when their reference doesn't include one
+const DefaultRegistry = "xpkg.upbound.io"
+
+// DefaultTimeout bounds listing the tags of a repository,
+// unless Options set another timeout
+const DefaultTimeout = 30 * time.Second
+
+// TagLister lists the tags of OCI repositories. It's the TagLister
+// injected into the addon reconciler, so that the scope can be
+// tested against a local registry
+type TagLister interface {
+	// ListTags lists the tags of repository, authenticating with the
+	// credentials of its registry host if there are any
+	ListTags(ctx context.Context, repository string, credentials Credentials) ([]string, error)
+}
+
+// Credential is the username and password of a registry
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Credentials are the credentials of registries, keyed by host
+type Credentials map[string]Credential
+
+// Options configure the TagLister returned by NewTagLister
+type Options struct {
+	// HTTPClient sends the registry requests. If nil,
+	// http.DefaultClient is used
+	HTTPClient *http.Client
+
+	// Timeout bounds listing the tags of a repository. If
+	// zero, DefaultTimeout is used
+	Timeout time.Duration
+}
+
+// NewTagLister returns a TagLister fetching the tokens registries
+// require, anonymously for repositories without credentials
+func NewTagLister(opts Options) TagLister {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &tagLister{
+		httpClient: opts.HTTPClient,
+		timeout:    timeout,
+		anonymous:  auth.NewCache(),
+	}
+}
+
+type tagLister struct {
+	httpClient *http.Client
+	timeout    time.Duration
+
+	// anonymous caches the tokens of anonymous requests. Tokens
+	// of credentials aren't cached, since they could be shared
+	// by addons with different credentials
+	anonymous auth.Cache
+}
+
+func (l *tagLister) ListTags(ctx context.Context, repository string, credentials Credentials) ([]string, error) {
+	ref, err := orasregistry.ParseReference(qualify(repository))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &auth.Client{Client: l.httpClient, Cache: l.anonymous}
+	if credential, ok := credentials[ref.Registry]; ok {
+		client.Cache = auth.NewCache()
+		client.Credential = func(context.Context, string) (auth.Credential, error) {
+			return auth.Credential{Username: credential.Username, Password: credential.Password}, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	return orasregistry.Tags(ctx, &remote.Repository{
+		Reference: ref,
+		Client:    client,
+	})
+}
+
+// AddDockerConfig adds the credentials of a .dockerconfigjson document,
+// the content of kubernetes.io/dockerconfigjson secrets. Hosts that
+// already have credentials keep them
+func (c Credentials) AddDockerConfig(config []byte) error {
+	var document struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &document); err != nil {
+		return err
+	}
+
+	for server, entry := range document.Auths {
+		credential := Credential{Username: entry.Username, Password: entry.Password}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return fmt.Errorf("invalid auth of %s: %w", server, err)
+			}
+			credential.Username, credential.Password, _ = strings.Cut(string(decoded), ":")
+		}
+
+		host := registryHost(server)
+		if _, ok := c[host]; !ok {
+			c[host] = credential
+		}
+	}
+	return nil
+}
+
+// registryHost returns the host of a docker config server,
+// which may be a URL such as https://index.docker.io/v1/
+func registryHost(server string) string {
+	if _, rest, found := strings.Cut(server, "://"); found {
+		server = rest
+	}
+	host, _, _ := strings.Cut(server, "/")
+	return host
+}
+
+// qualify prefixes repositories without a registry host with
+// the DefaultRegistry, the way Crossplane resolves them
+func qualify(repository string) string {
+	host, _, found := strings.Cut(repository, "/")
+	if found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return repository
+	}
+	return DefaultRegistry + "/" + repository
+}
@@ -0,0 +1,87 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/launchboxio/operator/internal/registry"
+	"github.com/launchboxio/operator/internal/registry/fake"
+)
+
+func TestListTags(t *testing.T) {
+	registry := fake.NewRegistry()
+	defer registry.Close()
+	registry.SetTags("launchboxio/addons/postgres", "v1.0.0", "v1.1.0", "latest")
+
+	tags, err := registry.TagLister().ListTags(context.TODO(), registry.Host()+"/launchboxio/addons/postgres", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v1.0.0", "v1.1.0", "latest"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	if _, err := registry.TagLister().ListTags(context.TODO(), registry.Host()+"/launchboxio/addons/redis", nil); err == nil {
+		t.Fatalf("expected unknown repositories to fail")
+	}
+}
+
+func TestListTagsWithCredentials(t *testing.T) {
+	fakeRegistry := fake.NewRegistry()
+	defer fakeRegistry.Close()
+	fakeRegistry.SetTags("launchboxio/addons/postgres", "v1.0.0")
+	fakeRegistry.SetCredentials("launchbox", "secret")
+	repository := fakeRegistry.Host() + "/launchboxio/addons/postgres"
+
+	if _, err := fakeRegistry.TagLister().ListTags(context.TODO(), repository, nil); err == nil {
+		t.Fatalf("expected anonymous requests to fail")
+	}
+
+	credentials := registry.Credentials{}
+	config := `{"auths": {"https://` + fakeRegistry.Host() + `/v1/": {"auth": "bGF1bmNoYm94OnNlY3JldA=="}}}`
+	if err := credentials.AddDockerConfig([]byte(config)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tags, err := fakeRegistry.TagLister().ListTags(context.TODO(), repository, credentials)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v1.0.0"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+}
+
+func TestListTagsTimesOut(t *testing.T) {
+	fakeRegistry := fake.NewRegistry()
+	defer fakeRegistry.Close()
+	fakeRegistry.SetTags("launchboxio/addons/postgres", "v1.0.0")
+	fakeRegistry.SetDelay(time.Second)
+
+	lister := registry.NewTagLister(registry.Options{HTTPClient: fakeRegistry.HTTPClient(), Timeout: 10 * time.Millisecond})
+	_, err := lister.ListTags(context.TODO(), fakeRegistry.Host()+"/launchboxio/addons/postgres", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the listing to time out, got %v", err)
+	}
+}
+
+func TestAddDockerConfig(t *testing.T) {
+	credentials := registry.Credentials{"ghcr.io": {Username: "first"}}
+	config := `{"auths": {
+		"ghcr.io": {"username": "second", "password": "secret"},
+		"registry.example.com": {"username": "launchbox", "password": "secret"}
+	}}`
+	if err := credentials.AddDockerConfig([]byte(config)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := registry.Credentials{
+		"ghcr.io":              {Username: "first"},
+		"registry.example.com": {Username: "launchbox", Password: "secret"},
+	}
+	if !reflect.DeepEqual(credentials, expected) {
+		t.Fatalf("expected the first credentials of each host, got %+v", credentials)
+	}
+}
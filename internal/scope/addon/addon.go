@@ -0,0 +1,166 @@
+package addon
+
+import (
+	"context"
+	"fmt"
+	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	"github.com/go-logr/logr"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/registry"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+)
+
+// healthCheckInterval is how often addons that aren't
+// healthy yet check their Configuration again
+const healthCheckInterval = 15 * time.Second
+
+type Scope struct {
+	Addon  *v1alpha1.Addon
+	Logger logr.Logger
+	Client client.Client
+	Scheme *runtime.Scheme
+
+	// TagLister lists the versions of addons following a channel
+	TagLister registry.TagLister
+
+	// APIReader reads the package pull secrets of the addon from
+	// PackagePullSecretNamespace, the namespace Crossplane runs in
+	APIReader                  client.Reader
+	PackagePullSecretNamespace string
+}
+
+func (s *Scope) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	original := s.Addon.Status.DeepCopy()
+
+	result, err := s.reconcile(ctx)
+	s.summarize()
+	if statusErr := s.patchStatus(ctx, original); statusErr != nil && err == nil {
+		return ctrl.Result{}, statusErr
+	}
+	return result, err
+}
+
+func (s *Scope) reconcile(ctx context.Context) (ctrl.Result, error) {
+	version, versionErr := s.resolveVersion(ctx)
+	if version == "" {
+		s.markFalse(v1alpha1.AddonPackagePulled, "NoVersion", "No version of the package to install")
+		if versionErr != nil {
+			return ctrl.Result{}, versionErr
+		}
+		return ctrl.Result{RequeueAfter: versionCheckInterval}, nil
+	}
+
+	revisions, err := s.listRevisions(ctx)
+	if err != nil {
+		s.Logger.Error(err, "Failed listing addon revisions")
+		return ctrl.Result{}, err
+	}
+
+	pkg := fmt.Sprintf("%s:%s", s.Addon.Spec.OciRegistry, version)
+	active := s.activeRevision(revisions)
+	if active != nil {
+		pkg = active.Spec.Package
+	}
+
+	configuration, err := s.reconcileConfiguration(ctx, pkg)
+	if err != nil {
+		s.markFalse(v1alpha1.AddonInstalled, "ConfigurationFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	if err := s.observeConfiguration(ctx, configuration); err != nil {
+		s.Logger.Error(err, "Failed observing addon configuration")
+		return ctrl.Result{}, err
+	}
+
+	if active != nil {
+		if err := s.activateRevision(ctx, configuration, active); err != nil {
+			s.markFalse(v1alpha1.AddonRevisionActivated, "ActivationFailed", err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+	s.observeRevisions(revisions)
+
+	// The version resolved last is kept installed, while
+	// listing the channel again is retried with backoff
+	if versionErr != nil {
+		return ctrl.Result{}, versionErr
+	}
+	if !isConditionTrue(configuration, crossplanev1.TypeHealthy) {
+		return ctrl.Result{RequeueAfter: healthCheckInterval}, nil
+	}
+	if s.Addon.Spec.Channel != "" {
+		return ctrl.Result{RequeueAfter: versionCheckInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// defaultRevisionHistoryLimit is how many inactive package
+// revisions are kept when the addon doesn't set a limit
+const defaultRevisionHistoryLimit = int64(10)
+
+// reconcileConfiguration creates or updates the Crossplane Configuration
+// installing pkg, so that it matches every package setting of the addon
+func (s *Scope) reconcileConfiguration(ctx context.Context, pkg string) (*crossplanev1.Configuration, error) {
+	configuration := &crossplanev1.Configuration{
+		ObjectMeta: metav1.ObjectMeta{Name: s.Addon.Name},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, s.Client, configuration, func() error {
+		configuration.Spec.PackageSpec = s.packageSpec(configuration.Spec.PackageSpec, pkg)
+		return controllerutil.SetControllerReference(s.Addon, configuration, s.Scheme)
+	})
+	if err != nil {
+		s.Logger.Error(err, "Failed reconciling addon configuration")
+		return nil, err
+	}
+	if result != controllerutil.OperationResultNone {
+		s.Logger.Info("Reconciled addon configuration", "operation", result)
+	}
+	return configuration, nil
+}
+
+// packageSpec returns the desired package settings of the Configuration
+// installing pkg.
+// Every setting is explicit, so that the defaults Crossplane applies
+// don't show up as changes. Settings the addon doesn't manage, such
+// as common labels, are kept from current
+func (s *Scope) packageSpec(current crossplanev1.PackageSpec, pkg string) crossplanev1.PackageSpec {
+	spec := s.Addon.Spec
+
+	pullPolicy := v1.PullAlways
+	if spec.PullPolicy != "" {
+		pullPolicy = v1.PullPolicy(spec.PullPolicy)
+	}
+
+	activationPolicy := crossplanev1.AutomaticActivation
+	if spec.ActivationPolicy == v1alpha1.AddonActivationManual {
+		activationPolicy = crossplanev1.ManualActivation
+	}
+
+	revisionHistoryLimit := defaultRevisionHistoryLimit
+	if spec.RevisionHistoryLimit != nil {
+		revisionHistoryLimit = *spec.RevisionHistoryLimit
+	}
+
+	var pullSecrets []v1.LocalObjectReference
+	if len(spec.PackagePullSecrets) > 0 {
+		pullSecrets = spec.PackagePullSecrets
+	}
+
+	return crossplanev1.PackageSpec{
+		Package:                     pkg,
+		PackagePullPolicy:           &pullPolicy,
+		RevisionActivationPolicy:    &activationPolicy,
+		RevisionHistoryLimit:        &revisionHistoryLimit,
+		PackagePullSecrets:          pullSecrets,
+		SkipDependencyResolution:    &spec.SkipDependencyResolution,
+		IgnoreCrossplaneConstraints: &spec.IgnoreCrossplaneConstraints,
+		CommonLabels:                current.CommonLabels,
+	}
+}
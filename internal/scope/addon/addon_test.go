@@ -0,0 +1,453 @@
+package addon
+
+import (
+	"context"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	"github.com/go-logr/logr"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/registry/fake"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScope(objs ...client.Object) *Scope {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = crossplanev1.AddToScheme(scheme)
+
+	addon := &v1alpha1.Addon{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", UID: "addon-uid"},
+		Spec: v1alpha1.AddonSpec{
+			Name:        "postgres",
+			OciRegistry: "ghcr.io/launchboxio/addons/postgres",
+			OciVersion:  "v1.0.0",
+		},
+	}
+	c := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(append(objs, addon)...).
+		WithStatusSubresource(addon).
+		Build()
+	return &Scope{
+		Addon:                      addon,
+		Logger:                     logr.Discard(),
+		Client:                     c,
+		Scheme:                     scheme,
+		APIReader:                  c,
+		PackagePullSecretNamespace: "crossplane-system",
+	}
+}
+
+func testConfiguration(revision string, conditions ...xpv1.Condition) *crossplanev1.Configuration {
+	configuration := &crossplanev1.Configuration{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres"},
+		Spec: crossplanev1.ConfigurationSpec{PackageSpec: crossplanev1.PackageSpec{
+			Package: "ghcr.io/launchboxio/addons/postgres:v1.0.0",
+		}},
+	}
+	configuration.Status.CurrentRevision = revision
+	configuration.Status.CurrentIdentifier = configuration.Spec.Package
+	configuration.SetConditions(conditions...)
+	return configuration
+}
+
+func testRevision(found, installed int64, conditions ...xpv1.Condition) *crossplanev1.ConfigurationRevision {
+	revision := &crossplanev1.ConfigurationRevision{ObjectMeta: metav1.ObjectMeta{Name: "postgres-abc123"}}
+	revision.Status.FoundDependencies = found
+	revision.Status.InstalledDependencies = installed
+	revision.SetConditions(conditions...)
+	return revision
+}
+
+func TestReconcileCreatesConfiguration(t *testing.T) {
+	scope := newTestScope()
+
+	result, err := scope.Reconcile(context.TODO(), ctrl.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != healthCheckInterval {
+		t.Fatalf("expected a requeue until the addon is healthy, got %s", result.RequeueAfter)
+	}
+
+	configuration := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, configuration); err != nil {
+		t.Fatalf("expected the configuration to be created: %v", err)
+	}
+	if scope.Addon.Status.Ready || meta.IsStatusConditionTrue(scope.Addon.Status.Conditions, v1alpha1.AddonReady) {
+		t.Fatalf("expected the addon not to be ready before the package is installed")
+	}
+}
+
+func TestReconcileMirrorsHealthyConfiguration(t *testing.T) {
+	scope := newTestScope(
+		testConfiguration("postgres-abc123", crossplanev1.Active(), crossplanev1.Healthy()),
+		testRevision(2, 2, crossplanev1.Healthy()),
+	)
+
+	result, err := scope.Reconcile(context.TODO(), ctrl.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != 0 {
+		t.Fatalf("expected no requeue once healthy, got %s", result.RequeueAfter)
+	}
+
+	status := scope.Addon.Status
+	if !status.Ready || !meta.IsStatusConditionTrue(status.Conditions, v1alpha1.AddonReady) {
+		t.Fatalf("expected the addon to be ready, got %+v", status.Conditions)
+	}
+	if status.CurrentRevision != "postgres-abc123" {
+		t.Fatalf("expected the current revision to be reported, got %q", status.CurrentRevision)
+	}
+	installed := meta.FindStatusCondition(status.Conditions, v1alpha1.AddonInstalled)
+	if installed.Reason != string(crossplanev1.ReasonActive) {
+		t.Fatalf("expected the Installed reason to be mirrored, got %q", installed.Reason)
+	}
+}
+
+func TestReconcileReportsFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		objs          []client.Object
+		conditionType string
+		reason        string
+	}{
+		{
+			name: "pull failure",
+			objs: []client.Object{
+				testConfiguration("", crossplanev1.Unpacking().WithMessage("cannot unpack package: failed to fetch package digest")),
+			},
+			conditionType: v1alpha1.AddonPackagePulled,
+			reason:        "PullFailed",
+		},
+		{
+			name: "missing dependencies",
+			objs: []client.Object{
+				testConfiguration("postgres-abc123", crossplanev1.Active(), crossplanev1.Unhealthy()),
+				testRevision(2, 1, crossplanev1.Unhealthy()),
+			},
+			conditionType: v1alpha1.AddonDependenciesResolved,
+			reason:        "MissingDependencies",
+		},
+		{
+			name: "resolution failure",
+			objs: []client.Object{
+				testConfiguration("postgres-abc123", crossplanev1.Active(), crossplanev1.UnknownHealth()),
+				testRevision(1, 0, crossplanev1.UnknownHealth().WithMessage("cannot resolve package dependencies: no valid version")),
+			},
+			conditionType: v1alpha1.AddonDependenciesResolved,
+			reason:        "ResolutionFailed",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scope := newTestScope(tc.objs...)
+			if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			condition := meta.FindStatusCondition(scope.Addon.Status.Conditions, tc.conditionType)
+			if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != tc.reason {
+				t.Fatalf("expected %s to be false with reason %s, got %+v", tc.conditionType, tc.reason, condition)
+			}
+			ready := meta.FindStatusCondition(scope.Addon.Status.Conditions, v1alpha1.AddonReady)
+			if ready.Status != metav1.ConditionFalse || ready.Reason != tc.reason {
+				t.Fatalf("expected Ready to report %s, got %+v", tc.reason, ready)
+			}
+		})
+	}
+}
+
+func TestReconcileUpdatesConfigurationSettings(t *testing.T) {
+	scope := newTestScope(testConfiguration("postgres-abc123"))
+	limit := int64(3)
+	scope.Addon.Spec.PullPolicy = "IfNotPresent"
+	scope.Addon.Spec.ActivationPolicy = v1alpha1.AddonActivationManual
+	scope.Addon.Spec.RevisionHistoryLimit = &limit
+	scope.Addon.Spec.PackagePullSecrets = []v1.LocalObjectReference{{Name: "registry-credentials"}}
+	scope.Addon.Spec.SkipDependencyResolution = true
+	scope.Addon.Spec.IgnoreCrossplaneConstraints = true
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	configuration := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, configuration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spec := configuration.Spec.PackageSpec
+	if *spec.PackagePullPolicy != v1.PullIfNotPresent {
+		t.Fatalf("expected the pull policy to be updated, got %s", *spec.PackagePullPolicy)
+	}
+	if *spec.RevisionActivationPolicy != crossplanev1.ManualActivation {
+		t.Fatalf("expected the activation policy to be updated, got %s", *spec.RevisionActivationPolicy)
+	}
+	if *spec.RevisionHistoryLimit != 3 {
+		t.Fatalf("expected the revision history limit to be updated, got %d", *spec.RevisionHistoryLimit)
+	}
+	if len(spec.PackagePullSecrets) != 1 || spec.PackagePullSecrets[0].Name != "registry-credentials" {
+		t.Fatalf("expected the pull secrets to be updated, got %v", spec.PackagePullSecrets)
+	}
+	if !*spec.SkipDependencyResolution || !*spec.IgnoreCrossplaneConstraints {
+		t.Fatalf("expected the dependency settings to be updated")
+	}
+	if len(configuration.OwnerReferences) != 1 {
+		t.Fatalf("expected the configuration to be owned by the addon")
+	}
+}
+
+func TestReconcileLeavesUnchangedConfiguration(t *testing.T) {
+	scope := newTestScope()
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configuration := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, configuration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unchanged := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, unchanged); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if unchanged.ResourceVersion != configuration.ResourceVersion {
+		t.Fatalf("expected the configuration not to be updated")
+	}
+}
+
+func testPackageRevision(name, pkg string, number int64, state crossplanev1.PackageRevisionDesiredState) *crossplanev1.ConfigurationRevision {
+	revision := &crossplanev1.ConfigurationRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{crossplanev1.LabelParentPackage: "postgres"},
+		},
+		Spec: crossplanev1.PackageRevisionSpec{
+			DesiredState: state,
+			Package:      pkg,
+			Revision:     number,
+		},
+	}
+	revision.SetConditions(crossplanev1.Healthy())
+	return revision
+}
+
+func TestReconcileActivatesCurrentRevision(t *testing.T) {
+	scope := newTestScope(
+		testConfiguration("postgres-0123456789ab", crossplanev1.Inactive()),
+		testPackageRevision("postgres-0123456789ab", "ghcr.io/launchboxio/addons/postgres:v1.0.0", 1, crossplanev1.PackageRevisionInactive),
+	)
+	scope.Addon.Spec.ActivationPolicy = v1alpha1.AddonActivationManual
+	scope.Addon.Spec.ActiveRevision = "postgres-0123456789ab"
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	revision := &crossplanev1.ConfigurationRevision{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres-0123456789ab"}, revision); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if revision.Spec.DesiredState != crossplanev1.PackageRevisionActive {
+		t.Fatalf("expected the revision to be activated, got %s", revision.Spec.DesiredState)
+	}
+	if !meta.IsStatusConditionTrue(scope.Addon.Status.Conditions, v1alpha1.AddonRevisionActivated) {
+		t.Fatalf("expected RevisionActivated to be true, got %+v", scope.Addon.Status.Conditions)
+	}
+	revisions := scope.Addon.Status.Revisions
+	if len(revisions) != 1 || !revisions[0].Active || !revisions[0].Healthy || revisions[0].Digest != "0123456789ab" {
+		t.Fatalf("unexpected revisions %+v", revisions)
+	}
+}
+
+func TestReconcileRollsBackToPreviousRevision(t *testing.T) {
+	scope := newTestScope(
+		testConfiguration("postgres-bbbbbbbbbbbb", crossplanev1.Active(), crossplanev1.Healthy()),
+		testPackageRevision("postgres-aaaaaaaaaaaa", "ghcr.io/launchboxio/addons/postgres:v1.0.0", 1, crossplanev1.PackageRevisionInactive),
+		testPackageRevision("postgres-bbbbbbbbbbbb", "ghcr.io/launchboxio/addons/postgres:v1.1.0", 2, crossplanev1.PackageRevisionActive),
+	)
+	scope.Addon.Spec.OciVersion = "v1.1.0"
+	scope.Addon.Spec.ActivationPolicy = v1alpha1.AddonActivationManual
+	scope.Addon.Spec.ActiveRevision = "postgres-aaaaaaaaaaaa"
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	configuration := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, configuration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if configuration.Spec.Package != "ghcr.io/launchboxio/addons/postgres:v1.0.0" {
+		t.Fatalf("expected the package of the previous revision to be installed, got %s", configuration.Spec.Package)
+	}
+	activated := meta.FindStatusCondition(scope.Addon.Status.Conditions, v1alpha1.AddonRevisionActivated)
+	if activated == nil || activated.Reason != "Pending" {
+		t.Fatalf("expected the activation to wait for the current revision, got %+v", activated)
+	}
+	revisions := scope.Addon.Status.Revisions
+	if len(revisions) != 2 || revisions[0].Name != "postgres-bbbbbbbbbbbb" {
+		t.Fatalf("expected the revisions newest first, got %+v", revisions)
+	}
+}
+
+func TestReconcileReportsMissingActiveRevision(t *testing.T) {
+	scope := newTestScope()
+	scope.Addon.Spec.ActivationPolicy = v1alpha1.AddonActivationManual
+	scope.Addon.Spec.ActiveRevision = "postgres-0123456789ab"
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	activated := meta.FindStatusCondition(scope.Addon.Status.Conditions, v1alpha1.AddonRevisionActivated)
+	if activated == nil || activated.Status != metav1.ConditionFalse || activated.Reason != "RevisionNotFound" {
+		t.Fatalf("expected RevisionNotFound, got %+v", activated)
+	}
+}
+
+func TestReconcileResolvesChannel(t *testing.T) {
+	registry := fake.NewRegistry()
+	defer registry.Close()
+	registry.SetTags("launchboxio/addons/postgres", "v1.3.9", "v1.4.0", "v1.4.2", "v1.5.0", "v1.4.3-rc.1", "latest")
+
+	scope := newTestScope()
+	scope.TagLister = registry.TagLister()
+	scope.Addon.Spec.OciRegistry = registry.Host() + "/launchboxio/addons/postgres"
+	scope.Addon.Spec.OciVersion = ""
+	scope.Addon.Spec.Channel = "~1.4"
+	if err := scope.Client.Update(context.TODO(), scope.Addon); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := scope.Reconcile(context.TODO(), ctrl.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter == 0 || result.RequeueAfter > versionCheckInterval {
+		t.Fatalf("expected the channel to be checked again, got %s", result.RequeueAfter)
+	}
+
+	configuration := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, configuration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if configuration.Spec.Package != scope.Addon.Spec.OciRegistry+":v1.4.2" {
+		t.Fatalf("expected the highest matching version to be installed, got %s", configuration.Spec.Package)
+	}
+	status := scope.Addon.Status
+	if status.ResolvedVersion != "v1.4.2" || status.LastVersionCheckTime == nil {
+		t.Fatalf("expected the resolved version to be reported, got %q at %v", status.ResolvedVersion, status.LastVersionCheckTime)
+	}
+
+	// The registry isn't listed again until the check interval passed
+	registry.SetTags("launchboxio/addons/postgres", "v1.4.2", "v1.4.5")
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if registry.Requests() != 1 || scope.Addon.Status.ResolvedVersion != "v1.4.2" {
+		t.Fatalf("expected the resolved version to be reused, got %d requests", registry.Requests())
+	}
+
+	checked := metav1.NewTime(scope.Addon.Status.LastVersionCheckTime.Add(-versionCheckInterval))
+	scope.Addon.Status.LastVersionCheckTime = &checked
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scope.Addon.Status.ResolvedVersion != "v1.4.5" {
+		t.Fatalf("expected the channel to be upgraded, got %q", scope.Addon.Status.ResolvedVersion)
+	}
+}
+
+func TestReconcileReportsUnmatchedChannel(t *testing.T) {
+	registry := fake.NewRegistry()
+	defer registry.Close()
+	registry.SetTags("launchboxio/addons/postgres", "v1.3.9")
+
+	scope := newTestScope()
+	scope.TagLister = registry.TagLister()
+	scope.Addon.Spec.OciRegistry = registry.Host() + "/launchboxio/addons/postgres"
+	scope.Addon.Spec.OciVersion = ""
+	scope.Addon.Spec.Channel = "~1.4"
+	if err := scope.Client.Update(context.TODO(), scope.Addon); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resolved := meta.FindStatusCondition(scope.Addon.Status.Conditions, v1alpha1.AddonVersionResolved)
+	if resolved == nil || resolved.Reason != "NoMatchingVersion" {
+		t.Fatalf("expected NoMatchingVersion, got %+v", resolved)
+	}
+	configuration := &crossplanev1.Configuration{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKey{Name: "postgres"}, configuration); err == nil {
+		t.Fatalf("expected no configuration without a version")
+	}
+}
+
+func TestReconcileResolvesChannelWithPullSecrets(t *testing.T) {
+	registry := fake.NewRegistry()
+	defer registry.Close()
+	registry.SetTags("launchboxio/addons/postgres", "v1.4.0")
+	registry.SetCredentials("launchbox", "secret")
+
+	config := `{"auths": {"` + registry.Host() + `": {"username": "launchbox", "password": "secret"}}}`
+	scope := newTestScope(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-credentials", Namespace: "crossplane-system"},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte(config)},
+	})
+	scope.TagLister = registry.TagLister()
+	scope.Addon.Spec.OciRegistry = registry.Host() + "/launchboxio/addons/postgres"
+	scope.Addon.Spec.OciVersion = ""
+	scope.Addon.Spec.Channel = "~1.4"
+	scope.Addon.Spec.PackagePullSecrets = []v1.LocalObjectReference{{Name: "registry-credentials"}}
+	if err := scope.Client.Update(context.TODO(), scope.Addon); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scope.Addon.Status.ResolvedVersion != "v1.4.0" {
+		t.Fatalf("expected the channel to be resolved with the pull secret, got %q", scope.Addon.Status.ResolvedVersion)
+	}
+}
+
+func TestReconcileRetriesFailedChannelListing(t *testing.T) {
+	registry := fake.NewRegistry()
+	defer registry.Close()
+
+	scope := newTestScope()
+	scope.TagLister = registry.TagLister()
+	scope.Addon.Spec.OciRegistry = registry.Host() + "/launchboxio/addons/postgres"
+	scope.Addon.Spec.OciVersion = ""
+	scope.Addon.Spec.Channel = "~1.4"
+	if err := scope.Client.Update(context.TODO(), scope.Addon); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := scope.Reconcile(context.TODO(), ctrl.Request{})
+	if err == nil || result.RequeueAfter != 0 {
+		t.Fatalf("expected the error to be retried with backoff, got %+v (%v)", result, err)
+	}
+	resolved := meta.FindStatusCondition(scope.Addon.Status.Conditions, v1alpha1.AddonVersionResolved)
+	if resolved == nil || resolved.Reason != "ListFailed" {
+		t.Fatalf("expected ListFailed, got %+v", resolved)
+	}
+}
@@ -0,0 +1,94 @@
+package addon
+
+import (
+	"context"
+	"fmt"
+	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+// listRevisions lists the ConfigurationRevisions of the addon package
+func (s *Scope) listRevisions(ctx context.Context) ([]crossplanev1.ConfigurationRevision, error) {
+	revisions := &crossplanev1.ConfigurationRevisionList{}
+	if err := s.Client.List(ctx, revisions, client.MatchingLabels{
+		crossplanev1.LabelParentPackage: s.Addon.Name,
+	}); err != nil {
+		return nil, err
+	}
+	return revisions.Items, nil
+}
+
+// activeRevision returns the revision named by ActiveRevision, when the
+// addon is manually activated. The package of OciVersion is installed
+// instead if the revision doesn't exist
+func (s *Scope) activeRevision(revisions []crossplanev1.ConfigurationRevision) *crossplanev1.ConfigurationRevision {
+	name := s.Addon.Spec.ActiveRevision
+	if name == "" || s.Addon.Spec.ActivationPolicy != v1alpha1.AddonActivationManual {
+		meta.RemoveStatusCondition(&s.Addon.Status.Conditions, v1alpha1.AddonRevisionActivated)
+		return nil
+	}
+	for i := range revisions {
+		if revisions[i].Name == name {
+			return &revisions[i]
+		}
+	}
+	s.markFalse(v1alpha1.AddonRevisionActivated, "RevisionNotFound", fmt.Sprintf("Revision %s doesn't exist", name))
+	return nil
+}
+
+// activateRevision activates the revision once it's the current revision of
+// the Configuration. Crossplane deactivates every other revision itself, and
+// would deactivate the revision again if it weren't the current one
+func (s *Scope) activateRevision(ctx context.Context, configuration *crossplanev1.Configuration, revision *crossplanev1.ConfigurationRevision) error {
+	if configuration.Status.CurrentRevision != revision.Name {
+		s.markFalse(v1alpha1.AddonRevisionActivated, "Pending",
+			fmt.Sprintf("Waiting for %s to become the current revision", revision.Name))
+		return nil
+	}
+
+	if revision.Spec.DesiredState != crossplanev1.PackageRevisionActive {
+		revision.Spec.DesiredState = crossplanev1.PackageRevisionActive
+		if err := s.Client.Update(ctx, revision); err != nil {
+			s.Logger.Error(err, "Failed activating addon revision", "revision", revision.Name)
+			return err
+		}
+		s.Logger.Info("Activated addon revision", "revision", revision.Name)
+	}
+	s.markTrue(v1alpha1.AddonRevisionActivated, "Activated",
+		fmt.Sprintf("Revision %d of %s is active", revision.Spec.Revision, revision.Spec.Package))
+	return nil
+}
+
+// observeRevisions lists the revisions of the package in the addon status
+func (s *Scope) observeRevisions(revisions []crossplanev1.ConfigurationRevision) {
+	var observed []v1alpha1.AddonRevision
+	for _, revision := range revisions {
+		observed = append(observed, v1alpha1.AddonRevision{
+			Name:     revision.Name,
+			Revision: revision.Spec.Revision,
+			Package:  revision.Spec.Package,
+			Digest:   revisionDigest(revision.Name),
+			Active:   revision.Spec.DesiredState == crossplanev1.PackageRevisionActive,
+			Healthy:  revision.GetCondition(crossplanev1.TypeHealthy).Status == v1.ConditionTrue,
+		})
+	}
+	sort.Slice(observed, func(i, j int) bool {
+		return observed[i].Revision > observed[j].Revision
+	})
+	s.Addon.Status.Revisions = observed
+}
+
+// revisionDigest returns the short package digest revisions are named
+// after, following the name of their Configuration
+func revisionDigest(name string) string {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return ""
+	}
+	return name[i+1:]
+}
@@ -0,0 +1,153 @@
+package addon
+
+import (
+	"context"
+	"fmt"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/conditions"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+)
+
+// Prefixes of the errors the Crossplane package manager reports
+// when a package can't be pulled, or its dependencies resolved
+const (
+	unpackErrorPrefix  = "cannot unpack package"
+	resolveErrorPrefix = "cannot resolve package dependencies"
+)
+
+// readinessConditions must all be true for an addon to be Ready,
+// in the order their failures are reported
+var readinessConditions = []string{
+	v1alpha1.AddonPackagePulled,
+	v1alpha1.AddonDependenciesResolved,
+	v1alpha1.AddonInstalled,
+	v1alpha1.AddonHealthy,
+}
+
+func (s *Scope) markTrue(conditionType string, reason string, message string) {
+	s.setCondition(conditionType, metav1.ConditionTrue, reason, message)
+}
+
+func (s *Scope) markFalse(conditionType string, reason string, message string) {
+	s.setCondition(conditionType, metav1.ConditionFalse, reason, message)
+}
+
+func (s *Scope) setCondition(conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	conditions.Set(&s.Addon.Status.Conditions, s.Addon.Generation, conditionType, status, reason, message)
+}
+
+// observeConfiguration mirrors the conditions and current
+// revision of the Configuration into the addon status
+func (s *Scope) observeConfiguration(ctx context.Context, configuration *crossplanev1.Configuration) error {
+	s.Addon.Status.CurrentRevision = configuration.Status.CurrentRevision
+	s.Addon.Status.CurrentIdentifier = configuration.Status.CurrentIdentifier
+
+	installed := configuration.GetCondition(crossplanev1.TypeInstalled)
+	healthy := configuration.GetCondition(crossplanev1.TypeHealthy)
+	s.mirrorCondition(v1alpha1.AddonInstalled, installed)
+	s.mirrorCondition(v1alpha1.AddonHealthy, healthy)
+
+	switch {
+	case installed.Reason == crossplanev1.ReasonUnpacking && strings.HasPrefix(installed.Message, unpackErrorPrefix):
+		s.markFalse(v1alpha1.AddonPackagePulled, "PullFailed", installed.Message)
+	case installed.Reason == crossplanev1.ReasonUnpacking:
+		s.markFalse(v1alpha1.AddonPackagePulled, "Pulling", "Waiting for the package to be pulled")
+	case configuration.Status.CurrentRevision == "":
+		s.markFalse(v1alpha1.AddonPackagePulled, "Pending", "Waiting for the package manager")
+	default:
+		s.markTrue(v1alpha1.AddonPackagePulled, "Pulled", fmt.Sprintf("Pulled %s", configuration.Status.CurrentIdentifier))
+	}
+
+	if configuration.Status.CurrentRevision == "" {
+		s.markFalse(v1alpha1.AddonDependenciesResolved, "Pending", "Waiting for a package revision")
+		return nil
+	}
+	revision := &crossplanev1.ConfigurationRevision{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: configuration.Status.CurrentRevision}, revision); err != nil {
+		if apierrors.IsNotFound(err) {
+			s.markFalse(v1alpha1.AddonDependenciesResolved, "Pending", "Waiting for a package revision")
+			return nil
+		}
+		return err
+	}
+	s.observeDependencies(revision)
+	return nil
+}
+
+// observeDependencies reports the dependency resolution of a revision
+func (s *Scope) observeDependencies(revision *crossplanev1.ConfigurationRevision) {
+	status := revision.Status
+	revisionHealthy := revision.GetCondition(crossplanev1.TypeHealthy)
+	switch {
+	case status.InvalidDependencies > 0:
+		s.markFalse(v1alpha1.AddonDependenciesResolved, "InvalidDependencies",
+			fmt.Sprintf("%d dependencies have invalid versions", status.InvalidDependencies))
+	case strings.HasPrefix(revisionHealthy.Message, resolveErrorPrefix):
+		s.markFalse(v1alpha1.AddonDependenciesResolved, "ResolutionFailed", revisionHealthy.Message)
+	case status.InstalledDependencies < status.FoundDependencies:
+		s.markFalse(v1alpha1.AddonDependenciesResolved, "MissingDependencies",
+			fmt.Sprintf("%d of %d dependencies are installed", status.InstalledDependencies, status.FoundDependencies))
+	default:
+		s.markTrue(v1alpha1.AddonDependenciesResolved, "Resolved",
+			fmt.Sprintf("%d dependencies are installed", status.InstalledDependencies))
+	}
+}
+
+// mirrorCondition copies a Crossplane condition into the addon
+// status. Conditions the package manager hasn't set yet are Unknown
+func (s *Scope) mirrorCondition(conditionType string, condition xpv1.Condition) {
+	reason := string(condition.Reason)
+	if reason == "" {
+		reason = "Pending"
+	}
+	status := metav1.ConditionUnknown
+	switch condition.Status {
+	case v1.ConditionTrue:
+		status = metav1.ConditionTrue
+	case v1.ConditionFalse:
+		status = metav1.ConditionFalse
+	}
+	s.setCondition(conditionType, status, reason, condition.Message)
+}
+
+// summarize computes the Ready condition of the addon, reporting
+// the first readiness condition that isn't true
+func (s *Scope) summarize() {
+	status := &s.Addon.Status
+	status.Ready = false
+	status.ObservedGeneration = s.Addon.Generation
+	for _, conditionType := range readinessConditions {
+		condition := meta.FindStatusCondition(status.Conditions, conditionType)
+		if condition == nil {
+			s.markFalse(v1alpha1.AddonReady, "Pending", fmt.Sprintf("Waiting for %s", conditionType))
+			return
+		}
+		if condition.Status != metav1.ConditionTrue {
+			s.markFalse(v1alpha1.AddonReady, condition.Reason, condition.Message)
+			return
+		}
+	}
+	status.Ready = true
+	s.markTrue(v1alpha1.AddonReady, "Healthy", "Addon package is installed and healthy")
+}
+
+// patchStatus writes the addon status if it has changed
+// from the status the reconciliation started with
+func (s *Scope) patchStatus(ctx context.Context, original *v1alpha1.AddonStatus) error {
+	if err := conditions.PatchStatus(ctx, s.Client, s.Addon, original, &s.Addon.Status); err != nil {
+		s.Logger.Error(err, "Failed updating addon status")
+		return err
+	}
+	return nil
+}
+
+func isConditionTrue(configuration *crossplanev1.Configuration, conditionType xpv1.ConditionType) bool {
+	return configuration.GetCondition(conditionType).Status == v1.ConditionTrue
+}
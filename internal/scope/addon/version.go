@@ -0,0 +1,114 @@
+package addon
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/registry"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+// versionCheckInterval is how often addons following
+// a channel check the registry for new versions
+const versionCheckInterval = 10 * time.Minute
+
+// resolveVersion returns the version of the package to install. Addons
+// following a channel install the highest tag of the registry matching
+// it, listed at most once every versionCheckInterval, and keep the
+// version resolved last while the registry can't be listed
+func (s *Scope) resolveVersion(ctx context.Context) (string, error) {
+	spec := s.Addon.Spec
+	status := &s.Addon.Status
+	if spec.Channel == "" {
+		status.ResolvedVersion = ""
+		status.LastVersionCheckTime = nil
+		meta.RemoveStatusCondition(&status.Conditions, v1alpha1.AddonVersionResolved)
+		return spec.OciVersion, nil
+	}
+
+	// Changes to the addon, such as its channel, are checked right away
+	resolved := meta.FindStatusCondition(status.Conditions, v1alpha1.AddonVersionResolved)
+	if resolved != nil && resolved.ObservedGeneration == s.Addon.Generation &&
+		status.LastVersionCheckTime != nil && time.Since(status.LastVersionCheckTime.Time) < versionCheckInterval {
+		return status.ResolvedVersion, nil
+	}
+
+	constraint, err := semver.NewConstraint(spec.Channel)
+	if err != nil {
+		s.markFalse(v1alpha1.AddonVersionResolved, "InvalidChannel", err.Error())
+		return status.ResolvedVersion, nil
+	}
+
+	credentials, err := s.pullCredentials(ctx)
+	if err != nil {
+		s.Logger.Error(err, "Failed reading addon pull secrets")
+		s.markFalse(v1alpha1.AddonVersionResolved, "PullSecretFailed", err.Error())
+		return status.ResolvedVersion, err
+	}
+
+	tags, err := s.TagLister.ListTags(ctx, spec.OciRegistry, credentials)
+	if err != nil {
+		s.Logger.Error(err, "Failed listing addon versions", "registry", spec.OciRegistry)
+		s.markFalse(v1alpha1.AddonVersionResolved, "ListFailed", err.Error())
+		return status.ResolvedVersion, err
+	}
+	now := metav1.Now()
+	status.LastVersionCheckTime = &now
+
+	version := highestMatching(constraint, tags)
+	if version == "" {
+		s.markFalse(v1alpha1.AddonVersionResolved, "NoMatchingVersion",
+			fmt.Sprintf("No version of %s matches %s", spec.OciRegistry, spec.Channel))
+		return status.ResolvedVersion, nil
+	}
+	if version != status.ResolvedVersion {
+		s.Logger.Info("Resolved addon version", "channel", spec.Channel, "version", version)
+	}
+	status.ResolvedVersion = version
+	s.markTrue(v1alpha1.AddonVersionResolved, "Resolved", fmt.Sprintf("Resolved %s to %s", spec.Channel, version))
+	return version, nil
+}
+
+// pullCredentials returns the registry credentials of the package pull
+// secrets, which are read from the namespace Crossplane runs in
+func (s *Scope) pullCredentials(ctx context.Context) (registry.Credentials, error) {
+	credentials := registry.Credentials{}
+	for _, ref := range s.Addon.Spec.PackagePullSecrets {
+		secret := &v1.Secret{}
+		key := types.NamespacedName{Name: ref.Name, Namespace: s.PackagePullSecretNamespace}
+		if err := s.APIReader.Get(ctx, key, secret); err != nil {
+			return nil, err
+		}
+		config, ok := secret.Data[v1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("pull secret %s has no %s key", ref.Name, v1.DockerConfigJsonKey)
+		}
+		if err := credentials.AddDockerConfig(config); err != nil {
+			return nil, fmt.Errorf("invalid pull secret %s: %w", ref.Name, err)
+		}
+	}
+	return credentials, nil
+}
+
+// highestMatching returns the highest tag matching the constraint.
+// Tags that aren't versions, such as latest, are skipped
+func highestMatching(constraint *semver.Constraints, tags []string) string {
+	var highest *semver.Version
+	var tag string
+	for _, t := range tags {
+		version, err := semver.NewVersion(t)
+		if err != nil || !constraint.Check(version) {
+			continue
+		}
+		if highest == nil || version.GreaterThan(highest) {
+			highest = version
+			tag = t
+		}
+	}
+	return tag
+}
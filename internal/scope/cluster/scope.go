@@ -3,111 +3,280 @@ package cluster
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/go-logr/logr"
 	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/charts"
+	"github.com/launchboxio/operator/internal/conditions"
+	"github.com/launchboxio/operator/internal/helm"
 	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strings"
 	"text/template"
 )
 
 type Scope struct {
 	Cluster *v1alpha1.Cluster
+	Logger  logr.Logger
 	Client  client.Client
+
+	// Projects are the projects referencing the cluster,
+	// which block its deletion
+	Projects []v1alpha1.Project
+
+	// Recorder emits events about the agent lifecycle
+	Recorder record.EventRecorder
+
+	// HelmClientFactory provides the Helm client managing the agent release
+	HelmClientFactory helm.ClientFactory
+
+	// APIReader reads chart source Secrets and ConfigMaps,
+	// which the manager doesn't cache
+	APIReader client.Reader
 }
 
-const clusterFinalizer = "core.launchboxhq.io/finalizer"
+const (
+	clusterFinalizer = "core.launchboxhq.io/finalizer"
+
+	// AgentNamespace is the namespace the agents are installed in
+	AgentNamespace = "lbx-system"
+
+	// maxReleaseNameLength is the longest release name Helm accepts
+	maxReleaseNameLength = 53
+)
+
+// AgentReleaseName returns the name of the agent Helm release of a
+// cluster, which also names its Deployment and heartbeat Lease. Each
+// cluster has its own release, so that clusters don't share an agent.
+// Names too long for Helm are shortened with a hash of the cluster
+func AgentReleaseName(cluster *v1alpha1.Cluster) string {
+	name := fmt.Sprintf("agent-%s-%s", cluster.Namespace, cluster.Name)
+	if len(name) <= maxReleaseNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(cluster.Namespace + "/" + cluster.Name))
+	return name[:maxReleaseNameLength-13] + "-" + hex.EncodeToString(sum[:])[:12]
+}
 
 func (s *Scope) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	conf := config.GetConfigOrDie()
-	helm, err := helmclient.NewClientFromRestConf(&helmclient.RestConfClientOptions{
-		RestConfig: conf,
-		Options: &helmclient.Options{
-			Debug:     true,
-			Namespace: "lbx-system",
-		},
-	})
+	original := s.Cluster.Status.DeepCopy()
 
+	result, err := s.reconcile(ctx)
+	if s.Cluster.GetDeletionTimestamp() != nil && !controllerutil.ContainsFinalizer(s.Cluster, clusterFinalizer) {
+		// The cluster is gone once the finalizer is removed
+		return result, err
+	}
+	s.summarize()
+	if statusErr := s.patchStatus(ctx, original); statusErr != nil && err == nil {
+		return ctrl.Result{}, statusErr
+	}
+	return result, err
+}
+
+// reconcile runs the cluster lifecycle: the finalizer is added first, so
+// that deletion is always observed, then the cluster is either deleted,
+// has its agent uninstalled when disabled, or has its agent installed
+func (s *Scope) reconcile(ctx context.Context) (ctrl.Result, error) {
+	if s.Cluster.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, s.reconcileDelete(ctx)
+	}
+
+	if !controllerutil.ContainsFinalizer(s.Cluster, clusterFinalizer) {
+		controllerutil.AddFinalizer(s.Cluster, clusterFinalizer)
+		if err := s.Client.Update(ctx, s.Cluster); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !s.Cluster.Spec.Agent.Enabled {
+		return ctrl.Result{}, s.uninstallAgent(ctx)
+	}
+	return s.reconcileAgent(ctx)
+}
+
+// reconcileDelete uninstalls the agent and releases the finalizer, once
+// no project references the cluster anymore
+func (s *Scope) reconcileDelete(ctx context.Context) error {
+	if !controllerutil.ContainsFinalizer(s.Cluster, clusterFinalizer) {
+		return nil
+	}
+
+	if len(s.Projects) > 0 {
+		blocking := make([]string, len(s.Projects))
+		for i, project := range s.Projects {
+			blocking[i] = client.ObjectKeyFromObject(&project).String()
+		}
+		sort.Strings(blocking)
+		s.Cluster.Status.BlockingProjects = blocking
+		s.markTrue(v1alpha1.ClusterDeletionBlocked, "ProjectsExist",
+			fmt.Sprintf("Cluster is referenced by %d projects: %s", len(blocking), strings.Join(blocking, ", ")))
+		s.Logger.Info("Waiting for projects to be deleted", "projects", blocking)
+		return nil
+	}
+
+	if err := s.uninstallAgent(ctx); err != nil {
+		return err
+	}
+
+	controllerutil.RemoveFinalizer(s.Cluster, clusterFinalizer)
+	return s.Client.Update(ctx, s.Cluster)
+}
+
+// reconcileAgent installs or upgrades the agent, and observes its health
+func (s *Scope) reconcileAgent(ctx context.Context) (ctrl.Result, error) {
+	source := s.Cluster.Spec.Charts.Agent
+	if source == nil {
+		source = &charts.DefaultAgentSource
+	}
+	chart, err := charts.Resolve(ctx, s.APIReader, s.Cluster.Namespace, source, "agent")
 	if err != nil {
+		s.markFalse(v1alpha1.ClusterAgentInstalled, "ChartUnavailable", err.Error())
 		return ctrl.Result{}, err
 	}
 
-	if s.Cluster.Spec.Agent.Enabled == false {
-		// TODO: Uninstall the agent if it was already installed
-		meta.SetStatusCondition(&s.Cluster.Status.Conditions, metav1.Condition{
-			Type:    "Ready",
-			Status:  metav1.ConditionTrue,
-			Reason:  "Installed",
-			Message: "",
-		})
-		return ctrl.Result{}, s.Client.Status().Update(ctx, s.Cluster)
+	helmClient, err := s.HelmClientFactory.ForNamespace(AgentNamespace, chart.Options)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	values, err := generateAgentValues(s.Cluster.Spec)
+	releaseName := AgentReleaseName(s.Cluster)
+	values, err := generateAgentValues(s.Cluster.Spec, releaseName)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 	chartSpec := &helmclient.ChartSpec{
-		ReleaseName: "agent",
-		ChartName:   "oci://ghcr.io/launchboxio/agent/helm/agent",
-		Namespace:   "lbx-system",
+		ReleaseName: releaseName,
+		ChartName:   chart.Name,
+		Namespace:   AgentNamespace,
 		Version:     s.Cluster.Spec.Agent.ChartVersion,
 		ValuesYaml:  string(values),
 	}
 
-	isAgentMarkedToBeDeleted := s.Cluster.GetDeletionTimestamp() != nil
-	if isAgentMarkedToBeDeleted {
-		if controllerutil.ContainsFinalizer(s.Cluster, clusterFinalizer) {
-			rel, err := helm.GetRelease(chartSpec.ReleaseName)
-			if rel != nil {
-				if err := helm.UninstallRelease(chartSpec); err != nil {
-					return ctrl.Result{}, err
-				}
-			}
-
-			controllerutil.RemoveFinalizer(s.Cluster, clusterFinalizer)
-			err = s.Client.Update(ctx, s.Cluster)
-			if err != nil {
-				return ctrl.Result{}, err
-			}
-		}
-		return ctrl.Result{}, nil
+	rel, err := s.reconcileRelease(ctx, helmClient, chart, chartSpec)
+	if err != nil {
+		s.Logger.Error(err, "Failed installing agent")
+		s.markFalse(v1alpha1.ClusterAgentInstalled, "InstallFailed", err.Error())
+		return ctrl.Result{}, err
 	}
 
-	if _, err = helm.InstallOrUpgradeChart(ctx, &helmclient.ChartSpec{
-		ReleaseName: "agent",
-		ChartName:   "oci://ghcr.io/launchboxio/agent/helm/agent",
-		Namespace:   "lbx-system",
-		Version:     s.Cluster.Spec.Agent.ChartVersion,
-		ValuesYaml:  string(values),
-	}, nil); err != nil {
+	requeueAfter, err := s.observeAgent(ctx, rel)
+	if err != nil {
+		s.Logger.Error(err, "Failed observing agent health")
 		return ctrl.Result{}, err
 	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
 
-	if !controllerutil.ContainsFinalizer(s.Cluster, clusterFinalizer) {
-		controllerutil.AddFinalizer(s.Cluster, clusterFinalizer)
-		err = s.Client.Update(ctx, s.Cluster)
-		if err != nil {
-			return ctrl.Result{}, err
+// uninstallAgent removes the agent release of the cluster and its secrets.
+// Cleanup is skipped once the release is gone and the agent status has
+// been cleared. Agents of other clusters are left untouched
+func (s *Scope) uninstallAgent(ctx context.Context) error {
+	helmClient, err := s.HelmClientFactory.ForNamespace(AgentNamespace, helm.Options{})
+	if err != nil {
+		return err
+	}
+
+	releaseName := AgentReleaseName(s.Cluster)
+	rel, err := helmClient.GetRelease(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		s.Logger.Error(err, "Failed looking up agent release")
+		return err
+	}
+	if rel == nil && s.Cluster.Status.Agent == nil {
+		return nil
+	}
+
+	if rel != nil {
+		s.Logger.Info("Uninstalling agent release")
+		if err := helmClient.UninstallReleaseByName(releaseName); err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+			s.Logger.Error(err, "Failed uninstalling agent")
+			return err
 		}
+		s.Recorder.Event(s.Cluster, v1.EventTypeNormal, "AgentUninstalled", "Uninstalled the agent release")
 	}
 
-	// Finally, update the status conditions
-	fmt.Println("Updating status conditions")
-	meta.SetStatusCondition(&s.Cluster.Status.Conditions, metav1.Condition{
-		Type:    "Ready",
-		Status:  metav1.ConditionTrue,
-		Reason:  "Installed",
-		Message: fmt.Sprintf("Chart %s has been installed", chartSpec.Version),
-	})
-	return ctrl.Result{}, s.Client.Status().Update(ctx, s.Cluster)
+	if err := s.deleteAgentSecrets(ctx); err != nil {
+		s.Logger.Error(err, "Failed deleting agent secrets")
+		return err
+	}
+
+	s.Cluster.Status.Agent = nil
+	for _, conditionType := range agentConditions {
+		meta.RemoveStatusCondition(&s.Cluster.Status.Conditions, conditionType)
+	}
+	return nil
+}
+
+// deleteAgentSecrets removes the secrets created by the agent chart and
+// any release history Helm left behind, so that no agent credentials
+// remain once it's uninstalled. The user provided CredentialsRef is kept
+func (s *Scope) deleteAgentSecrets(ctx context.Context) error {
+	releaseName := AgentReleaseName(s.Cluster)
+	for _, labels := range []client.MatchingLabels{
+		{"app.kubernetes.io/instance": releaseName},
+		{"owner": "helm", "name": releaseName},
+	} {
+		if err := s.Client.DeleteAllOf(ctx, &v1.Secret{}, client.InNamespace(AgentNamespace), labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileRelease installs or upgrades the agent release, unless the
+// chart and values are unchanged since the last successful install
+// and the release is deployed. It returns the current release
+func (s *Scope) reconcileRelease(ctx context.Context, helmClient helmclient.Client, chart *charts.Chart, chartSpec *helmclient.ChartSpec) (*release.Release, error) {
+	if s.Cluster.Status.Agent == nil {
+		s.Cluster.Status.Agent = &v1alpha1.ClusterAgentStatus{}
+	}
+	status := s.Cluster.Status.Agent
+
+	rel, err := helmClient.GetRelease(chartSpec.ReleaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, err
+	}
+
+	hash := conditions.ReleaseHash(chartSpec)
+	if rel != nil && rel.Info != nil {
+		if rel.Info.Status.IsPending() {
+			s.Logger.Info("Waiting for pending agent release operation", "status", rel.Info.Status)
+			return rel, nil
+		}
+		if rel.Info.Status == release.StatusDeployed && status.ValuesHash == hash {
+			return rel, nil
+		}
+	}
+
+	if chart.Repository != nil {
+		if err := helmClient.AddOrUpdateChartRepo(*chart.Repository); err != nil {
+			return nil, err
+		}
+	}
+
+	s.Logger.Info("Installing or upgrading agent release")
+	rel, err = helmClient.InstallOrUpgradeChart(ctx, chartSpec, nil)
+	if err != nil {
+		return nil, err
+	}
+	status.ValuesHash = hash
+	return rel, nil
 }
 
-func generateAgentValues(spec v1alpha1.ClusterSpec) ([]byte, error) {
+// generateAgentValues renders the agent values. The agent renews the
+// Lease named leaseName as its heartbeat
+func generateAgentValues(spec v1alpha1.ClusterSpec, leaseName string) ([]byte, error) {
 	tmpl, err := template.New("values").Parse(`
 image:
   {{- if .Agent.Repository }}
@@ -125,6 +294,7 @@ agent:
   streamUrl: {{ .Launchbox.StreamUrl }}
   clusterId: {{ .ClusterId }}
   channel: {{ .Launchbox.Channel }}
+  leaseName: {{ .LeaseName }}
 credentialsSecret:
   name: {{ .CredentialsRef.Name }}
 `)
@@ -132,6 +302,9 @@ credentialsSecret:
 		return nil, err
 	}
 	var values bytes.Buffer
-	err = tmpl.Execute(&values, spec)
+	err = tmpl.Execute(&values, struct {
+		v1alpha1.ClusterSpec
+		LeaseName string
+	}{spec, leaseName})
 	return values.Bytes(), err
 }
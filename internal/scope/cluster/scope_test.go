@@ -0,0 +1,416 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/helm/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScope(cluster *v1alpha1.Cluster, objs ...client.Object) (*Scope, *fake.Client) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+
+	factory := fake.NewClientFactory()
+	c := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(append(objs, cluster)...).
+		WithStatusSubresource(cluster).
+		Build()
+	return &Scope{
+		Cluster:           cluster,
+		Logger:            logr.Discard(),
+		Recorder:          record.NewFakeRecorder(10),
+		Client:            c,
+		HelmClientFactory: factory,
+		APIReader:         c,
+	}, factory.Client("lbx-system")
+}
+
+func testCluster() *v1alpha1.Cluster {
+	return &v1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "lbx-system"},
+		Spec: v1alpha1.ClusterSpec{
+			CredentialsRef: &v1.SecretReference{Name: "credentials"},
+			Agent:          v1alpha1.ClusterAgentSpec{Enabled: true, ChartVersion: "0.1.0"},
+		},
+	}
+}
+
+func TestReconcileInstallsAgent(t *testing.T) {
+	scope, helm := newTestScope(testCluster())
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rel, err := helm.GetRelease(AgentReleaseName(scope.Cluster))
+	if err != nil {
+		t.Fatalf("expected the agent to be installed: %v", err)
+	}
+	if rel.Chart.Metadata.Version != "0.1.0" {
+		t.Fatalf("expected chart version 0.1.0, got %s", rel.Chart.Metadata.Version)
+	}
+	if len(scope.Cluster.Finalizers) != 1 {
+		t.Fatalf("expected the finalizer to be added")
+	}
+}
+
+func TestReconcileUninstallsAgentOnDelete(t *testing.T) {
+	scope, helm := newTestScope(testCluster())
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := scope.Client.Delete(context.TODO(), scope.Cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(scope.Cluster), scope.Cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Uninstalls != 1 {
+		t.Fatalf("expected the agent to be uninstalled, got %d uninstalls", helm.Uninstalls)
+	}
+}
+
+func agentDeployment(available v1.ConditionStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      AgentReleaseName(testCluster()),
+			Namespace: AgentNamespace,
+			Labels:    map[string]string{"app.kubernetes.io/instance": AgentReleaseName(testCluster())},
+		},
+		Status: appsv1.DeploymentStatus{
+			AvailableReplicas: 1,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: available},
+			},
+		},
+	}
+}
+
+func agentLease(renewed time.Time) *coordinationv1.Lease {
+	duration := int32(30)
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: AgentReleaseName(testCluster()), Namespace: AgentNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			RenewTime:            &renewTime,
+			LeaseDurationSeconds: &duration,
+		},
+	}
+}
+
+func TestReconcileReadyWhenAgentConnected(t *testing.T) {
+	scope, _ := newTestScope(testCluster(), agentDeployment(v1.ConditionTrue), agentLease(time.Now()))
+
+	result, err := scope.Reconcile(context.TODO(), ctrl.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(scope.Cluster.Status.Conditions, v1alpha1.ClusterReady) {
+		t.Fatalf("expected the cluster to be ready, got %+v", scope.Cluster.Status.Conditions)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > 30*time.Second {
+		t.Fatalf("expected a requeue before the lease expires, got %s", result.RequeueAfter)
+	}
+
+	agent := scope.Cluster.Status.Agent
+	if agent.ChartVersion != "0.1.0" || agent.ReleaseStatus != "deployed" || agent.AvailableReplicas != 1 {
+		t.Fatalf("unexpected agent status %+v", agent)
+	}
+
+	stored := &v1alpha1.Cluster{}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(scope.Cluster), stored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(stored.Status.Conditions, v1alpha1.ClusterReady) {
+		t.Fatalf("expected the status to be persisted")
+	}
+}
+
+func TestReconcileNotReadyWhenHeartbeatExpired(t *testing.T) {
+	scope, _ := newTestScope(testCluster(), agentDeployment(v1.ConditionTrue), agentLease(time.Now().Add(-time.Minute)))
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ready := meta.FindStatusCondition(scope.Cluster.Status.Conditions, v1alpha1.ClusterReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "HeartbeatExpired" {
+		t.Fatalf("expected the cluster not to be ready, got %+v", ready)
+	}
+}
+
+func TestReconcileReadyWithoutHeartbeat(t *testing.T) {
+	scope, _ := newTestScope(testCluster(), agentDeployment(v1.ConditionTrue))
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	connected := meta.FindStatusCondition(scope.Cluster.Status.Conditions, v1alpha1.ClusterConnected)
+	if connected == nil || connected.Status != metav1.ConditionUnknown {
+		t.Fatalf("expected the connection to be unknown, got %+v", connected)
+	}
+	if !meta.IsStatusConditionTrue(scope.Cluster.Status.Conditions, v1alpha1.ClusterReady) {
+		t.Fatalf("expected the cluster to be ready without a heartbeat, got %+v", scope.Cluster.Status.Conditions)
+	}
+}
+
+func TestReconcileNotReadyWhenLeaseRemoved(t *testing.T) {
+	lease := agentLease(time.Now())
+	scope, _ := newTestScope(testCluster(), agentDeployment(v1.ConditionTrue), lease)
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := scope.Client.Delete(context.TODO(), lease); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ready := meta.FindStatusCondition(scope.Cluster.Status.Conditions, v1alpha1.ClusterReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "LeaseNotFound" {
+		t.Fatalf("expected the cluster not to be ready, got %+v", ready)
+	}
+}
+
+func TestReconcileNotReadyWhenAgentUnavailable(t *testing.T) {
+	scope, _ := newTestScope(testCluster(), agentDeployment(v1.ConditionFalse), agentLease(time.Now()))
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ready := meta.FindStatusCondition(scope.Cluster.Status.Conditions, v1alpha1.ClusterReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "Unavailable" {
+		t.Fatalf("expected the cluster not to be ready, got %+v", ready)
+	}
+}
+
+func TestReconcileSkipsUnchangedRelease(t *testing.T) {
+	scope, helm := newTestScope(testCluster())
+
+	for i := 0; i < 2; i++ {
+		if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if helm.Installs != 1 {
+		t.Fatalf("expected a single install, got %d", helm.Installs)
+	}
+}
+
+// agentSecret returns a secret created by the agent release of a cluster
+func agentSecret(cluster *v1alpha1.Cluster) *v1.Secret {
+	return &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      AgentReleaseName(cluster) + "-token",
+		Namespace: AgentNamespace,
+		Labels:    map[string]string{"app.kubernetes.io/instance": AgentReleaseName(cluster)},
+	}}
+}
+
+func TestAgentReleaseName(t *testing.T) {
+	cluster := testCluster()
+	if name := AgentReleaseName(cluster); name != "agent-lbx-system-default" {
+		t.Fatalf("unexpected release name %s", name)
+	}
+
+	cluster.Name = strings.Repeat("a", 60)
+	name := AgentReleaseName(cluster)
+	if len(name) != maxReleaseNameLength {
+		t.Fatalf("expected the release name to be shortened, got %s", name)
+	}
+	cluster.Name = strings.Repeat("a", 59) + "b"
+	if AgentReleaseName(cluster) == name {
+		t.Fatalf("expected shortened release names to differ")
+	}
+}
+
+func TestReconcileUninstallsDisabledAgent(t *testing.T) {
+	credentials := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: AgentNamespace}}
+	scope, helm := newTestScope(testCluster(), agentSecret(testCluster()), credentials)
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	scope.Cluster.Spec.Agent.Enabled = false
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Uninstalls != 1 {
+		t.Fatalf("expected the agent to be uninstalled, got %d uninstalls", helm.Uninstalls)
+	}
+	if len(scope.Cluster.Finalizers) != 1 {
+		t.Fatalf("expected the finalizer to be kept")
+	}
+	if scope.Cluster.Status.Agent != nil {
+		t.Fatalf("expected the agent status to be cleared")
+	}
+
+	secrets := &v1.SecretList{}
+	if err := scope.Client.List(context.TODO(), secrets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != "credentials" {
+		t.Fatalf("expected only the credentials secret to remain, got %v", secrets.Items)
+	}
+
+	events := scope.Recorder.(*record.FakeRecorder).Events
+	if len(events) != 1 {
+		t.Fatalf("expected an AgentUninstalled event")
+	}
+	if event := <-events; event != "Normal AgentUninstalled Uninstalled the agent release" {
+		t.Fatalf("unexpected event %q", event)
+	}
+}
+
+func TestReconcileDisablingAgentKeepsOtherClusters(t *testing.T) {
+	other := testCluster()
+	other.Name = "other"
+	scope, helm := newTestScope(testCluster(), other, agentSecret(testCluster()), agentSecret(other))
+	otherScope := *scope
+	otherScope.Cluster = other
+	for _, s := range []*Scope{scope, &otherScope} {
+		if _, err := s.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(helm.Releases) != 2 {
+		t.Fatalf("expected an agent release per cluster, got %d", len(helm.Releases))
+	}
+
+	scope.Cluster.Spec.Agent.Enabled = false
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := helm.GetRelease(AgentReleaseName(scope.Cluster)); err == nil {
+		t.Fatalf("expected the agent of the disabled cluster to be uninstalled")
+	}
+	if _, err := helm.GetRelease(AgentReleaseName(other)); err != nil {
+		t.Fatalf("expected the agent of the other cluster to be kept: %v", err)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(agentSecret(other)), &v1.Secret{}); err != nil {
+		t.Fatalf("expected the secrets of the other cluster to be kept: %v", err)
+	}
+}
+
+// deleteCluster marks the cluster of a scope as deleted
+func deleteCluster(t *testing.T, scope *Scope) {
+	if err := scope.Client.Delete(context.TODO(), scope.Cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(scope.Cluster), scope.Cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReconcileBlocksDeletionWhileProjectsExist(t *testing.T) {
+	scope, helm := newTestScope(testCluster())
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deleteCluster(t, scope)
+
+	scope.Projects = []v1alpha1.Project{
+		{ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "team-b"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "production", Namespace: "team-a"}},
+	}
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Uninstalls != 0 {
+		t.Fatalf("expected the agent to be kept while projects exist")
+	}
+	if !meta.IsStatusConditionTrue(scope.Cluster.Status.Conditions, v1alpha1.ClusterDeletionBlocked) {
+		t.Fatalf("expected the deletion to be blocked")
+	}
+	blocking := scope.Cluster.Status.BlockingProjects
+	if len(blocking) != 2 || blocking[0] != "team-a/production" || blocking[1] != "team-b/staging" {
+		t.Fatalf("unexpected blocking projects %v", blocking)
+	}
+
+	scope.Projects = nil
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Uninstalls != 1 {
+		t.Fatalf("expected the agent to be uninstalled, got %d uninstalls", helm.Uninstalls)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(scope.Cluster), &v1alpha1.Cluster{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the cluster to be deleted, got %v", err)
+	}
+}
+
+func TestReconcileDeleteKeepsOtherClusters(t *testing.T) {
+	other := testCluster()
+	other.Name = "other"
+	scope, helm := newTestScope(testCluster(), other, agentSecret(testCluster()), agentSecret(other))
+	otherScope := *scope
+	otherScope.Cluster = other
+
+	// Alternating reconciles don't upgrade each other's release
+	for i := 0; i < 2; i++ {
+		for _, s := range []*Scope{scope, &otherScope} {
+			if _, err := s.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	if helm.Installs != 2 {
+		t.Fatalf("expected a single install per cluster, got %d", helm.Installs)
+	}
+
+	deleteCluster(t, scope)
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := helm.GetRelease(AgentReleaseName(other)); err != nil {
+		t.Fatalf("expected the agent of the other cluster to be kept: %v", err)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(agentSecret(other)), &v1.Secret{}); err != nil {
+		t.Fatalf("expected the secrets of the other cluster to be kept: %v", err)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(scope.Cluster), &v1alpha1.Cluster{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the cluster to be deleted, got %v", err)
+	}
+}
+
+func TestReconcileDeletesDisabledCluster(t *testing.T) {
+	cluster := testCluster()
+	cluster.Spec.Agent.Enabled = false
+	scope, _ := newTestScope(cluster)
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(scope.Cluster.Finalizers) != 1 {
+		t.Fatalf("expected the finalizer to be added")
+	}
+	deleteCluster(t, scope)
+
+	if _, err := scope.Reconcile(context.TODO(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scope.Client.Get(context.TODO(), client.ObjectKeyFromObject(scope.Cluster), &v1alpha1.Cluster{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the cluster to be deleted, got %v", err)
+	}
+}
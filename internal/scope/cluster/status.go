@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/conditions"
+	"helm.sh/helm/v3/pkg/release"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// defaultLeaseDuration is used when the agent
+// lease doesn't set its own duration
+const defaultLeaseDuration = 60 * time.Second
+
+// agentConditions must all be true for a cluster with
+// an enabled agent to be Ready, in order of precedence
+var agentConditions = []string{
+	v1alpha1.ClusterAgentInstalled,
+	v1alpha1.ClusterAgentAvailable,
+	v1alpha1.ClusterConnected,
+}
+
+func (s *Scope) markTrue(conditionType string, reason string, message string) {
+	conditions.MarkTrue(&s.Cluster.Status.Conditions, s.Cluster.Generation, conditionType, reason, message)
+}
+
+func (s *Scope) markFalse(conditionType string, reason string, message string) {
+	conditions.MarkFalse(&s.Cluster.Status.Conditions, s.Cluster.Generation, conditionType, reason, message)
+}
+
+func (s *Scope) markUnknown(conditionType string, reason string, message string) {
+	conditions.MarkUnknown(&s.Cluster.Status.Conditions, s.Cluster.Generation, conditionType, reason, message)
+}
+
+// observeAgent reports the agent release, the availability of its
+// Deployment and its heartbeat. It returns how long until the
+// heartbeat expires, to re-evaluate the Connected condition
+func (s *Scope) observeAgent(ctx context.Context, rel *release.Release) (time.Duration, error) {
+	status := s.Cluster.Status.Agent
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		status.ChartVersion = rel.Chart.Metadata.Version
+	}
+	if rel.Info != nil {
+		status.ReleaseStatus = rel.Info.Status.String()
+	}
+	status.ReleaseRevision = rel.Version
+
+	if rel.Info != nil && rel.Info.Status == release.StatusDeployed {
+		s.markTrue(v1alpha1.ClusterAgentInstalled, "Deployed", fmt.Sprintf("Agent chart %s is deployed", status.ChartVersion))
+	} else {
+		s.markFalse(v1alpha1.ClusterAgentInstalled, "ReleaseNotDeployed", fmt.Sprintf("Agent release is %s", status.ReleaseStatus))
+	}
+
+	if err := s.observeDeployment(ctx); err != nil {
+		return 0, err
+	}
+	return s.observeHeartbeat(ctx, time.Now())
+}
+
+func (s *Scope) observeDeployment(ctx context.Context) error {
+	deployments := &appsv1.DeploymentList{}
+	if err := s.Client.List(ctx, deployments,
+		client.InNamespace(AgentNamespace),
+		client.MatchingLabels{"app.kubernetes.io/instance": AgentReleaseName(s.Cluster)},
+	); err != nil {
+		return err
+	}
+
+	status := s.Cluster.Status.Agent
+	status.AvailableReplicas = 0
+	if len(deployments.Items) == 0 {
+		s.markFalse(v1alpha1.ClusterAgentAvailable, "DeploymentNotFound", "Agent deployment doesn't exist")
+		return nil
+	}
+
+	for _, deployment := range deployments.Items {
+		status.AvailableReplicas += deployment.Status.AvailableReplicas
+		if !isDeploymentAvailable(&deployment) {
+			s.markFalse(v1alpha1.ClusterAgentAvailable, "Unavailable", fmt.Sprintf("Deployment %s is unavailable", deployment.Name))
+			return nil
+		}
+	}
+	s.markTrue(v1alpha1.ClusterAgentAvailable, "Available", fmt.Sprintf("%d agent replicas are available", status.AvailableReplicas))
+	return nil
+}
+
+func isDeploymentAvailable(deployment *appsv1.Deployment) bool {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// observeHeartbeat sets the Connected condition from the agent lease,
+// and returns how long until the lease expires while it's valid. Agents
+// that never renewed a lease don't report heartbeats, so Connected is
+// left Unknown until a heartbeat is first seen
+func (s *Scope) observeHeartbeat(ctx context.Context, now time.Time) (time.Duration, error) {
+	lease := &coordinationv1.Lease{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: AgentReleaseName(s.Cluster), Namespace: AgentNamespace}, lease); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return 0, err
+		}
+		lease = nil
+	}
+
+	var renewed *metav1.MicroTime
+	if lease != nil {
+		renewed = lease.Spec.RenewTime
+		if renewed == nil {
+			renewed = lease.Spec.AcquireTime
+		}
+	}
+	if renewed == nil {
+		if s.Cluster.Status.Agent.LastHeartbeatTime == nil {
+			s.markUnknown(v1alpha1.ClusterConnected, "NoHeartbeat", "Agent hasn't sent a heartbeat yet")
+		} else {
+			s.markFalse(v1alpha1.ClusterConnected, "LeaseNotFound", fmt.Sprintf("Agent lease is gone since its heartbeat at %s", s.Cluster.Status.Agent.LastHeartbeatTime.Format(time.RFC3339)))
+		}
+		return 0, nil
+	}
+	s.Cluster.Status.Agent.LastHeartbeatTime = renewed
+
+	duration := defaultLeaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	expires := renewed.Add(duration)
+	if !now.Before(expires) {
+		s.markFalse(v1alpha1.ClusterConnected, "HeartbeatExpired", fmt.Sprintf("Agent hasn't sent a heartbeat since %s", renewed.Format(time.RFC3339)))
+		return 0, nil
+	}
+	s.markTrue(v1alpha1.ClusterConnected, "Heartbeat", "Agent is connected")
+	return expires.Sub(now), nil
+}
+
+// summarize computes the Ready condition of the cluster. Clusters
+// without an agent are ready as soon as they're reconciled, otherwise
+// the agent has to be installed and available, and connected once it
+// has sent a heartbeat
+func (s *Scope) summarize() {
+	if !s.Cluster.Spec.Agent.Enabled {
+		s.markTrue(v1alpha1.ClusterReady, "AgentDisabled", "Agent is disabled")
+		return
+	}
+	for _, conditionType := range agentConditions {
+		condition := meta.FindStatusCondition(s.Cluster.Status.Conditions, conditionType)
+		if condition == nil {
+			s.markFalse(v1alpha1.ClusterReady, "Pending", fmt.Sprintf("Waiting for %s", conditionType))
+			return
+		}
+		if conditionType == v1alpha1.ClusterConnected && condition.Status == metav1.ConditionUnknown {
+			s.markTrue(v1alpha1.ClusterReady, "AgentAvailable", "Agent is installed and available")
+			return
+		}
+		if condition.Status != metav1.ConditionTrue {
+			s.markFalse(v1alpha1.ClusterReady, condition.Reason, condition.Message)
+			return
+		}
+	}
+	s.markTrue(v1alpha1.ClusterReady, "AgentConnected", "Agent is installed and connected")
+}
+
+// patchStatus writes the cluster status if it has changed
+// from the status the reconciliation started with
+func (s *Scope) patchStatus(ctx context.Context, original *v1alpha1.ClusterStatus) error {
+	if err := conditions.PatchStatus(ctx, s.Client, s.Cluster, original, &s.Cluster.Status); err != nil {
+		s.Logger.Error(err, "Failed updating cluster status")
+		return err
+	}
+	return nil
+}
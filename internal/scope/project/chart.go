@@ -0,0 +1,152 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/charts"
+	"github.com/launchboxio/operator/internal/versions"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// chartVersion returns the vcluster chart version to deploy. A project
+// override is applied right away, while a new cluster default is rolled
+// out to a limited number of projects at a time. Queued projects keep
+// their installed version, and true is returned until they get a slot.
+// Without a cluster default, projects keep their installed version too,
+// and only the first install pulls the latest chart. Chart archives have
+// no version to choose, so they're installed without one or a rollout
+func (scope *Scope) chartVersion(ctx context.Context, chart *charts.Chart) (string, bool, error) {
+	if chart.Archive {
+		meta.RemoveStatusCondition(&scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading)
+		return "", false, nil
+	}
+	if scope.Project.Spec.VclusterChartVersion != "" {
+		meta.RemoveStatusCondition(&scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading)
+		return scope.Project.Spec.VclusterChartVersion, false, nil
+	}
+
+	target := scope.Cluster.Spec.Vcluster.ChartVersion
+	installed, err := scope.installedChartVersion(chart)
+	if err != nil {
+		return "", false, err
+	}
+	if target == "" && installed != "" {
+		meta.RemoveStatusCondition(&scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading)
+		return installed, false, nil
+	}
+	if target == "" || installed == "" || chartVersionMatches(installed, target) {
+		// The default changed back before the project got a slot
+		if condition := meta.FindStatusCondition(scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading); condition != nil && condition.Reason == "Queued" {
+			meta.RemoveStatusCondition(&scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading)
+		}
+		return target, false, nil
+	}
+	if meta.IsStatusConditionTrue(scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading) {
+		return target, false, nil
+	}
+
+	limit := scope.Cluster.Spec.Vcluster.MaxConcurrentUpgrades
+	if limit < 1 {
+		limit = 1
+	}
+	upgrading, err := scope.countChartUpgrades(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if upgrading >= limit {
+		scope.Logger.Info("Waiting to upgrade vcluster chart", "from", installed, "to", target, "upgrading", upgrading)
+		scope.markFalse(v1alpha1.ProjectChartUpgrading, "Queued", fmt.Sprintf("Waiting for %d projects to upgrade to chart %s", upgrading, target))
+		return installed, true, nil
+	}
+
+	scope.Logger.Info("Upgrading vcluster chart", "from", installed, "to", target)
+	scope.markTrue(v1alpha1.ProjectChartUpgrading, "RollingOut", fmt.Sprintf("Upgrading chart from %s to %s", installed, target))
+	return target, false, nil
+}
+
+// countChartUpgrades counts the other projects of the cluster holding a
+// rollout slot. Their status is read from the API server instead of the
+// cache, which may not have seen a slot claimed by the previous reconcile
+func (scope *Scope) countChartUpgrades(ctx context.Context) (int32, error) {
+	upgrading := int32(0)
+	for _, cached := range scope.ClusterProjects {
+		if cached.UID == scope.Project.UID {
+			continue
+		}
+		project := &v1alpha1.Project{}
+		if err := scope.APIReader.Get(ctx, client.ObjectKeyFromObject(&cached), project); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		if meta.IsStatusConditionTrue(project.Status.Conditions, v1alpha1.ProjectChartUpgrading) {
+			upgrading++
+		}
+	}
+	return upgrading, nil
+}
+
+// installedChartVersion returns the chart version of the vcluster release,
+// reading it from the release when it wasn't recorded in the status yet
+func (scope *Scope) installedChartVersion(chart *charts.Chart) (string, error) {
+	status := &scope.Project.Status
+	if status.VclusterChartVersion != "" {
+		return status.VclusterChartVersion, nil
+	}
+
+	helmClient, err := scope.helmClient(chart.Options)
+	if err != nil {
+		return "", err
+	}
+	rel, err := helmClient.GetRelease(scope.Project.Spec.Slug)
+	if err != nil {
+		if isReleaseNotFoundError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return "", nil
+	}
+	status.VclusterChartVersion = rel.Chart.Metadata.Version
+	return status.VclusterChartVersion, nil
+}
+
+// observeChartRollout frees the rollout slot of the project
+// once the vcluster runs the new chart version
+func (scope *Scope) observeChartRollout(ctx context.Context, distro versions.Distro) error {
+	condition := meta.FindStatusCondition(scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading)
+	if condition == nil || condition.Reason != "RollingOut" {
+		return nil
+	}
+	if !chartVersionMatches(scope.Project.Status.VclusterChartVersion, scope.Cluster.Spec.Vcluster.ChartVersion) {
+		return nil
+	}
+
+	rolledOut, err := scope.isRolledOut(ctx, distro)
+	if err != nil || !rolledOut {
+		return err
+	}
+	scope.markFalse(v1alpha1.ProjectChartUpgrading, "Completed", fmt.Sprintf("Upgraded to chart %s", scope.Project.Status.VclusterChartVersion))
+	return nil
+}
+
+// chartVersionMatches returns true if the installed chart version is the
+// target version, or satisfies it when the target is a constraint such
+// as ~0.16, which Helm resolves to the latest matching version
+func chartVersionMatches(installed string, target string) bool {
+	if installed == target {
+		return true
+	}
+	constraint, err := semver.NewConstraint(target)
+	if err != nil {
+		return false
+	}
+	version, err := semver.NewVersion(installed)
+	return err == nil && constraint.Check(version)
+}
@@ -0,0 +1,160 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/charts"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newChartScope(installed string, siblings ...v1alpha1.Project) *Scope {
+	objs := []client.Object{}
+	for i := range siblings {
+		objs = append(objs, &siblings[i])
+	}
+	scope, helm := newTestScope(testProject(), objs...)
+	scope.Cluster.Spec.Vcluster = v1alpha1.ClusterVclusterSpec{ChartVersion: "0.16.0", MaxConcurrentUpgrades: 1}
+	scope.ClusterProjects = siblings
+	if installed != "" {
+		helm.Releases["testing"] = &release.Release{
+			Name:  "testing",
+			Chart: &chart.Chart{Metadata: &chart.Metadata{Version: installed}},
+			Info:  &release.Info{Status: release.StatusDeployed},
+		}
+	}
+	return scope
+}
+
+func upgradingProject() v1alpha1.Project {
+	return v1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "sibling", Namespace: "default", UID: "sibling"},
+		Status: v1alpha1.ProjectStatus{Conditions: []metav1.Condition{{
+			Type:   v1alpha1.ProjectChartUpgrading,
+			Status: metav1.ConditionTrue,
+			Reason: "RollingOut",
+		}}},
+	}
+}
+
+func TestChartVersionInstallsDefault(t *testing.T) {
+	scope := newChartScope("", upgradingProject())
+
+	version, queued, err := scope.chartVersion(context.TODO(), testChart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "0.16.0" || queued {
+		t.Fatalf("expected new projects to install the default right away, got %s (queued: %t)", version, queued)
+	}
+}
+
+func TestChartVersionRollsOutDefault(t *testing.T) {
+	scope := newChartScope("0.15.0")
+
+	version, queued, err := scope.chartVersion(context.TODO(), testChart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "0.16.0" || queued {
+		t.Fatalf("expected an upgrade to 0.16.0, got %s (queued: %t)", version, queued)
+	}
+	if !meta.IsStatusConditionTrue(scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading) {
+		t.Fatal("expected the project to take a rollout slot")
+	}
+}
+
+func TestChartVersionQueuesRollout(t *testing.T) {
+	scope := newChartScope("0.15.0", upgradingProject())
+
+	version, queued, err := scope.chartVersion(context.TODO(), testChart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "0.15.0" || !queued {
+		t.Fatalf("expected to keep 0.15.0 while queued, got %s (queued: %t)", version, queued)
+	}
+	if scope.Project.Status.VclusterChartVersion != "0.15.0" {
+		t.Fatalf("expected the installed version to be recorded, got %q", scope.Project.Status.VclusterChartVersion)
+	}
+}
+
+func TestChartVersionOverride(t *testing.T) {
+	scope := newChartScope("0.15.0", upgradingProject())
+	scope.Project.Spec.VclusterChartVersion = "0.17.0"
+
+	version, queued, err := scope.chartVersion(context.TODO(), testChart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "0.17.0" || queued {
+		t.Fatalf("expected the override to apply right away, got %s (queued: %t)", version, queued)
+	}
+}
+
+func TestChartVersionCountsUncachedRollouts(t *testing.T) {
+	scope := newChartScope("0.15.0", upgradingProject())
+
+	// The cache hasn't seen the sibling claim its slot yet
+	scope.ClusterProjects[0].Status.Conditions = nil
+
+	version, queued, err := scope.chartVersion(context.TODO(), testChart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "0.15.0" || !queued {
+		t.Fatalf("expected the rollout to be queued, got %s (queued: %t)", version, queued)
+	}
+}
+
+func TestChartVersionKeepsInstalledWithoutDefault(t *testing.T) {
+	scope := newChartScope("0.15.0")
+	scope.Cluster.Spec.Vcluster.ChartVersion = ""
+
+	version, queued, err := scope.chartVersion(context.TODO(), testChart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "0.15.0" || queued {
+		t.Fatalf("expected the installed version to be kept, got %q (queued: %t)", version, queued)
+	}
+}
+
+func TestChartVersionReleasesSlotForArchives(t *testing.T) {
+	scope := newChartScope("0.15.0")
+	scope.Project.Status.Conditions = upgradingProject().Status.Conditions
+
+	version, queued, err := scope.chartVersion(context.TODO(), &charts.Chart{Name: "/charts/vcluster.tgz", Archive: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "" || queued {
+		t.Fatalf("expected archives to install without a version, got %q (queued: %t)", version, queued)
+	}
+	if meta.FindStatusCondition(scope.Project.Status.Conditions, v1alpha1.ProjectChartUpgrading) != nil {
+		t.Fatal("expected the rollout slot to be released")
+	}
+}
+
+func TestChartVersionMatches(t *testing.T) {
+	for _, tc := range []struct {
+		installed string
+		target    string
+		matches   bool
+	}{
+		{"0.16.0", "0.16.0", true},
+		{"0.16.4", "~0.16", true},
+		{"0.15.2", "~0.16", false},
+		{"0.16.0", "0.17.0", false},
+		{"dev", "0.16.0", false},
+	} {
+		if matches := chartVersionMatches(tc.installed, tc.target); matches != tc.matches {
+			t.Fatalf("%s against %s: expected %t, got %t", tc.installed, tc.target, tc.matches, matches)
+		}
+	}
+}
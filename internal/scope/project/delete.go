@@ -0,0 +1,178 @@
+package project
+
+import (
+	"context"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/helm"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"time"
+)
+
+// ReconcileDelete tears down everything created for a project. Addon
+// claims are removed first, followed by the ProviderConfigs pointing at
+// the vcluster kubeconfig, the vcluster release itself, and finally
+// the project namespace. The finalizer is only removed once the
+// addon claims are gone, so their providers can still reach the vcluster
+func (scope *Scope) ReconcileDelete(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(scope.Project, projectFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if scope.Project.Status.Phase != v1alpha1.ProjectPhaseDeleting {
+		original := scope.Project.Status.DeepCopy()
+		scope.Project.Status.Phase = v1alpha1.ProjectPhaseDeleting
+		scope.markFalse(v1alpha1.ProjectReady, "Deleting", "Project is being deleted")
+		if err := scope.patchStatus(ctx, original); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	remaining, err := scope.deleteAddons(ctx)
+	if err != nil {
+		scope.Logger.Error(err, "Failed deleting addon claims")
+		return ctrl.Result{}, err
+	}
+	if remaining > 0 {
+		scope.Logger.Info("Waiting for addon claims to be removed", "remaining", remaining)
+		return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+	}
+
+	if err := scope.deleteProviders(ctx); err != nil {
+		scope.Logger.Error(err, "Failed deleting provider configs")
+		return ctrl.Result{}, err
+	}
+
+	if err := scope.uninstallRelease(); err != nil {
+		scope.Logger.Error(err, "Failed uninstalling vcluster release")
+		return ctrl.Result{}, err
+	}
+
+	namespace := &v1.Namespace{}
+	if err := scope.Client.Get(ctx, types.NamespacedName{Name: scope.Project.Spec.Slug}, namespace); err != nil {
+		if !apierrors.IsNotFound(err) {
+			scope.Logger.Error(err, "Failed lookup for namespace")
+			return ctrl.Result{}, err
+		}
+	} else if namespace.GetDeletionTimestamp() == nil {
+		scope.Logger.Info("Deleting namespace")
+		if err := scope.Client.Delete(ctx, namespace); err != nil && !apierrors.IsNotFound(err) {
+			scope.Logger.Error(err, "Failed deleting namespace")
+			return ctrl.Result{}, err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(scope.Project, projectFinalizer)
+	if err := scope.Client.Update(ctx, scope.Project); err != nil {
+		scope.Logger.Error(err, "Failed removing project finalizer")
+		return ctrl.Result{}, err
+	}
+	scope.HelmClientFactory.Evict(scope.Project.Spec.Slug)
+	return ctrl.Result{}, nil
+}
+
+// deleteAddons issues a delete for every addon claim of the project,
+// and returns the number of claims that still exist
+func (scope *Scope) deleteAddons(ctx context.Context) (int, error) {
+	remaining := 0
+	namespace := scope.Project.Spec.Slug
+	for _, claim := range scope.addonClaims() {
+		resource := scope.DynamicClient.Resource(schema.GroupVersionResource{
+			Group:    claim.Group,
+			Version:  claim.Version,
+			Resource: claim.Resource,
+		}).Namespace(namespace)
+
+		existing, err := resource.Get(ctx, claim.Name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return remaining, err
+		}
+
+		remaining++
+		if existing.GetDeletionTimestamp() != nil {
+			continue
+		}
+		scope.Logger.Info("Deleting addon claim", "resource", claim.Resource, "name", claim.Name)
+		if err := resource.Delete(ctx, claim.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return remaining, err
+		}
+	}
+	return remaining, nil
+}
+
+// addonClaims returns the claims created for the addons of the project.
+// Claims recorded in the status include addons since removed from the
+// spec, while the spec covers claims created before they were recorded
+func (scope *Scope) addonClaims() []v1alpha1.ProjectAddonClaimReference {
+	var claims []v1alpha1.ProjectAddonClaimReference
+	seen := map[v1alpha1.ProjectAddonClaimReference]bool{}
+	add := func(claim v1alpha1.ProjectAddonClaimReference) {
+		if !seen[claim] {
+			seen[claim] = true
+			claims = append(claims, claim)
+		}
+	}
+
+	for _, addon := range scope.Project.Spec.Addons {
+		gvr := addonResource(addon)
+		add(v1alpha1.ProjectAddonClaimReference{
+			Group:    gvr.Group,
+			Version:  gvr.Version,
+			Resource: gvr.Resource,
+			Name:     addonInstallationName(addon),
+		})
+	}
+
+	identifiers := make([]string, 0, len(scope.Project.Status.Addons))
+	for identifier := range scope.Project.Status.Addons {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+	for _, identifier := range identifiers {
+		if claim := scope.Project.Status.Addons[identifier].Claim; claim != nil {
+			add(*claim)
+		}
+	}
+	return claims
+}
+
+func (scope *Scope) deleteProviders(ctx context.Context) error {
+	for _, provider := range providerConfigResources {
+		scope.Logger.Info("Deleting provider " + provider.Group + "/" + provider.Version)
+		err := scope.DynamicClient.Resource(provider).Delete(ctx, scope.Project.Spec.Slug, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (scope *Scope) uninstallRelease() error {
+	helmClient, err := scope.helmClient(helm.Options{})
+	if err != nil {
+		return err
+	}
+
+	rel, err := helmClient.GetRelease(scope.Project.Spec.Slug)
+	if err != nil {
+		if isReleaseNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	if rel == nil {
+		return nil
+	}
+
+	scope.Logger.Info("Uninstalling vcluster release")
+	return helmClient.UninstallReleaseByName(rel.Name)
+}
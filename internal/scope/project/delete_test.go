@@ -0,0 +1,149 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	helmfake "github.com/launchboxio/operator/internal/helm/fake"
+	"helm.sh/helm/v3/pkg/release"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+var testClaimResource = schema.GroupVersionResource{Group: "addons.launchboxhq.io", Version: "v1alpha1", Resource: "postgresinstances"}
+
+func testClaim(name string) *unstructured.Unstructured {
+	claim := &unstructured.Unstructured{}
+	claim.SetAPIVersion(testClaimResource.GroupVersion().String())
+	claim.SetKind("PostgresInstance")
+	claim.SetNamespace("testing")
+	claim.SetName(name)
+	return claim
+}
+
+func testProviderConfig(resource schema.GroupVersionResource) *unstructured.Unstructured {
+	provider := &unstructured.Unstructured{}
+	provider.SetAPIVersion(resource.GroupVersion().String())
+	provider.SetKind("ProviderConfig")
+	provider.SetName("testing")
+	return provider
+}
+
+func newDeleteScope(t *testing.T, claims ...runtime.Object) (*Scope, *dynamicfake.FakeDynamicClient, *helmfake.Client) {
+	now := metav1.Now()
+	project := testProject()
+	project.DeletionTimestamp = &now
+	project.Finalizers = []string{projectFinalizer}
+	project.Spec.Addons = []v1alpha1.ProjectAddonSpec{{
+		AddonName:        "postgres",
+		InstallationName: "database",
+		Group:            testClaimResource.Group,
+		Version:          testClaimResource.Version,
+		Resource:         "PostgresInstance",
+	}}
+	scope, helm := newTestScope(project, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "testing"}})
+	helm.Releases["testing"] = &release.Release{Name: "testing", Info: &release.Info{Status: release.StatusDeployed}}
+
+	objects := append(claims,
+		testProviderConfig(providerConfigResources[0]),
+		testProviderConfig(providerConfigResources[1]),
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		testClaimResource:          "PostgresInstanceList",
+		providerConfigResources[0]: "ProviderConfigList",
+		providerConfigResources[1]: "ProviderConfigList",
+	}, objects...)
+	scope.DynamicClient = dynamicClient
+
+	// ProviderConfigs must be gone before the vcluster is uninstalled
+	dynamicClient.PrependReactor("delete", "providerconfigs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if helm.Uninstalls > 0 {
+			t.Errorf("expected %s to be deleted before the release", action.GetResource())
+		}
+		return false, nil, nil
+	})
+	return scope, dynamicClient, helm
+}
+
+func reconcileTestDelete(t *testing.T, scope *Scope) ctrl.Result {
+	result, err := scope.ReconcileDelete(context.TODO(), ctrl.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return result
+}
+
+func TestReconcileDeleteOrder(t *testing.T) {
+	scope, dynamicClient, helm := newDeleteScope(t, testClaim("database"))
+
+	// Claims are deleted first, and block the rest of the teardown
+	if result := reconcileTestDelete(t, scope); result.RequeueAfter == 0 {
+		t.Fatal("expected a requeue while the claim is removed")
+	}
+	if _, err := dynamicClient.Resource(testClaimResource).Namespace("testing").Get(context.TODO(), "database", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the claim to be deleted, got %v", err)
+	}
+	if _, err := dynamicClient.Resource(providerConfigResources[0]).Get(context.TODO(), "testing", metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected provider configs to be kept while claims exist, got %v", err)
+	}
+	if helm.Uninstalls != 0 || !controllerutil.ContainsFinalizer(scope.Project, projectFinalizer) {
+		t.Fatal("expected the release and finalizer to be kept while claims exist")
+	}
+	if scope.Project.Status.Phase != v1alpha1.ProjectPhaseDeleting {
+		t.Fatalf("expected the project to be deleting, got %s", scope.Project.Status.Phase)
+	}
+
+	// Then ProviderConfigs, the release and the namespace
+	if result := reconcileTestDelete(t, scope); result.RequeueAfter != 0 {
+		t.Fatalf("expected the project to be torn down, got %+v", result)
+	}
+	for _, provider := range providerConfigResources {
+		if _, err := dynamicClient.Resource(provider).Get(context.TODO(), "testing", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected %s to be deleted, got %v", provider, err)
+		}
+	}
+	if helm.Uninstalls != 1 {
+		t.Fatalf("expected the release to be uninstalled, got %d uninstalls", helm.Uninstalls)
+	}
+	namespace := &v1.Namespace{}
+	if err := scope.Client.Get(context.TODO(), types.NamespacedName{Name: "testing"}, namespace); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the namespace to be deleted, got %v", err)
+	}
+	if controllerutil.ContainsFinalizer(scope.Project, projectFinalizer) {
+		t.Fatal("expected the finalizer to be removed")
+	}
+	if evicted := scope.HelmClientFactory.(*helmfake.ClientFactory).Evicted; len(evicted) != 1 || evicted[0] != "testing" {
+		t.Fatalf("expected the helm client to be evicted, got %v", evicted)
+	}
+}
+
+func TestReconcileDeleteRemovesClaimsMissingFromSpec(t *testing.T) {
+	scope, dynamicClient, _ := newDeleteScope(t, testClaim("database"), testClaim("removed"))
+	scope.Project.Status.Addons = map[string]*v1alpha1.ProjectAddonStatus{
+		"postgres/removed": {Claim: &v1alpha1.ProjectAddonClaimReference{
+			Group:    testClaimResource.Group,
+			Version:  testClaimResource.Version,
+			Resource: testClaimResource.Resource,
+			Name:     "removed",
+		}},
+	}
+
+	if result := reconcileTestDelete(t, scope); result.RequeueAfter == 0 {
+		t.Fatal("expected a requeue while the claims are removed")
+	}
+	for _, name := range []string{"database", "removed"} {
+		if _, err := dynamicClient.Resource(testClaimResource).Namespace("testing").Get(context.TODO(), name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected claim %s to be deleted, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package project
+
+import (
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// scheduleLookback bounds the search for the previous run of a
+// schedule, and covers schedules running at least once a week
+const scheduleLookback = 8 * 24 * time.Hour
+
+// reconcileHibernation evaluates the hibernation settings of the project,
+// and returns how long until the hibernation state may change next
+func (scope *Scope) reconcileHibernation(now time.Time) (time.Duration, error) {
+	spec := scope.Project.Spec.Hibernation
+	if spec == nil {
+		scope.Project.Status.Hibernation = nil
+		meta.RemoveStatusCondition(&scope.Project.Status.Conditions, v1alpha1.ProjectHibernating)
+		return 0, nil
+	}
+
+	status := scope.Project.Status.Hibernation
+	if status == nil {
+		status = &v1alpha1.ProjectHibernationStatus{}
+		scope.Project.Status.Hibernation = status
+	}
+
+	hibernating, reason, next, err := hibernationState(spec, scope.lastActivity(), now)
+	if err != nil {
+		scope.markFalse(v1alpha1.ProjectHibernating, "InvalidSchedule", err.Error())
+		return 0, err
+	}
+
+	if hibernating != status.Hibernating || status.LastTransitionTime == nil {
+		scope.Logger.Info("Updating hibernation state", "hibernating", hibernating, "reason", reason)
+		transition := metav1.NewTime(now)
+		status.LastTransitionTime = &transition
+	}
+	status.Hibernating = hibernating
+	status.Reason = reason
+	status.NextTransitionTime = nil
+
+	if hibernating {
+		scope.markTrue(v1alpha1.ProjectHibernating, reason, "Project is hibernating")
+	} else {
+		scope.markFalse(v1alpha1.ProjectHibernating, "Awake", "Project is awake")
+	}
+
+	if next.IsZero() {
+		return 0, nil
+	}
+	nextTransition := metav1.NewTime(next)
+	status.NextTransitionTime = &nextTransition
+	return next.Sub(now), nil
+}
+
+// isPaused returns true if the project is paused,
+// either manually or by its hibernation settings
+func (scope *Scope) isPaused() bool {
+	hibernation := scope.Project.Status.Hibernation
+	return scope.Project.Spec.Paused || (hibernation != nil && hibernation.Hibernating)
+}
+
+// lastActivity is the latest of the project's creation, its last
+// wake up, and the timestamp in the last activity annotation
+func (scope *Scope) lastActivity() time.Time {
+	lastActivity := scope.Project.CreationTimestamp.Time
+
+	hibernation := scope.Project.Status.Hibernation
+	if hibernation != nil && !hibernation.Hibernating && hibernation.LastTransitionTime != nil {
+		lastActivity = latest(lastActivity, hibernation.LastTransitionTime.Time)
+	}
+
+	if value, ok := scope.Project.Annotations[v1alpha1.LastActivityAnnotation]; ok {
+		if activity, err := time.Parse(time.RFC3339, value); err == nil {
+			lastActivity = latest(lastActivity, activity)
+		} else {
+			scope.Logger.Info("Ignoring invalid last activity annotation", "value", value)
+		}
+	}
+	return lastActivity
+}
+
+// hibernationState evaluates hibernation settings at a point in time. It
+// returns whether the project should be hibernating and why, along with
+// the next time the result may change
+func hibernationState(spec *v1alpha1.ProjectHibernationSpec, lastActivity time.Time, now time.Time) (bool, string, time.Time, error) {
+	var next time.Time
+	hibernating := false
+	reason := ""
+
+	for _, schedule := range spec.Schedules {
+		sleeping, transition, err := evaluateSchedule(schedule, lastActivity, now)
+		if err != nil {
+			return false, "", next, err
+		}
+		if sleeping {
+			hibernating = true
+			reason = "Schedule"
+		}
+		next = earliest(next, transition)
+	}
+
+	if spec.IdleTimeout != nil && spec.IdleTimeout.Duration > 0 {
+		idleAt := lastActivity.Add(spec.IdleTimeout.Duration)
+		if now.Before(idleAt) {
+			next = earliest(next, idleAt)
+		} else if !hibernating {
+			hibernating = true
+			reason = "IdleTimeout"
+		}
+	}
+	return hibernating, reason, next, nil
+}
+
+// evaluateSchedule returns whether a schedule is sleeping, and its next
+// transition. Activity after the schedule last went to sleep keeps the
+// project awake until the next time it's scheduled to sleep
+func evaluateSchedule(schedule v1alpha1.HibernationSchedule, lastActivity time.Time, now time.Time) (bool, time.Time, error) {
+	location := time.UTC
+	if schedule.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return false, time.Time{}, fmt.Errorf("invalid time zone %q: %w", schedule.TimeZone, err)
+		}
+	}
+
+	sleep, err := cron.ParseStandard(schedule.Sleep)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid sleep schedule %q: %w", schedule.Sleep, err)
+	}
+	wake, err := cron.ParseStandard(schedule.Wake)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid wake schedule %q: %w", schedule.Wake, err)
+	}
+
+	local := now.In(location)
+	lastSleep := previousRun(sleep, local)
+	lastWake := previousRun(wake, local)
+	sleeping := !lastSleep.IsZero() && lastSleep.After(lastWake) && lastActivity.Before(lastSleep)
+	return sleeping, earliest(sleep.Next(local), wake.Next(local)), nil
+}
+
+// previousRun returns the last time a schedule ran before now
+func previousRun(schedule cron.Schedule, now time.Time) time.Time {
+	var last time.Time
+	for t := schedule.Next(now.Add(-scheduleLookback)); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		last = t
+	}
+	return last
+}
+
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func latest(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,94 @@
+package project
+
+import (
+	"testing"
+	"time"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHibernationStateSchedule(t *testing.T) {
+	spec := &v1alpha1.ProjectHibernationSpec{
+		Schedules: []v1alpha1.HibernationSchedule{{
+			Sleep:    "0 20 * * *",
+			Wake:     "0 8 * * 1-5",
+			TimeZone: "Europe/Berlin",
+		}},
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	lastActivity := time.Date(2023, 11, 1, 12, 0, 0, 0, berlin)
+
+	for _, tc := range []struct {
+		now         time.Time
+		hibernating bool
+		next        time.Time
+	}{
+		// Wednesday afternoon
+		{time.Date(2023, 11, 8, 15, 0, 0, 0, berlin), false, time.Date(2023, 11, 8, 20, 0, 0, 0, berlin)},
+		// Wednesday night
+		{time.Date(2023, 11, 8, 23, 0, 0, 0, berlin), true, time.Date(2023, 11, 9, 8, 0, 0, 0, berlin)},
+		// Saturday, sleeping since Friday night
+		{time.Date(2023, 11, 11, 12, 0, 0, 0, berlin), true, time.Date(2023, 11, 11, 20, 0, 0, 0, berlin)},
+		// Monday morning
+		{time.Date(2023, 11, 13, 9, 0, 0, 0, berlin), false, time.Date(2023, 11, 13, 20, 0, 0, 0, berlin)},
+	} {
+		hibernating, _, next, err := hibernationState(spec, lastActivity, tc.now)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.now, err)
+		}
+		if hibernating != tc.hibernating {
+			t.Fatalf("%s: expected hibernating=%v", tc.now, tc.hibernating)
+		}
+		if !next.Equal(tc.next) {
+			t.Fatalf("%s: expected next transition at %s, got %s", tc.now, tc.next, next)
+		}
+	}
+}
+
+func TestHibernationStateActivityWakesSchedule(t *testing.T) {
+	spec := &v1alpha1.ProjectHibernationSpec{
+		Schedules: []v1alpha1.HibernationSchedule{{Sleep: "0 20 * * *", Wake: "0 8 * * *"}},
+	}
+	now := time.Date(2023, 11, 8, 23, 0, 0, 0, time.UTC)
+
+	hibernating, _, _, err := hibernationState(spec, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hibernating {
+		t.Fatal("expected activity after sleeping to wake the project")
+	}
+}
+
+func TestHibernationStateIdleTimeout(t *testing.T) {
+	spec := &v1alpha1.ProjectHibernationSpec{
+		IdleTimeout: &metav1.Duration{Duration: 2 * time.Hour},
+	}
+	now := time.Date(2023, 11, 8, 12, 0, 0, 0, time.UTC)
+
+	hibernating, _, next, err := hibernationState(spec, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hibernating || !next.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected to be awake until %s, got hibernating=%v next=%s", now.Add(time.Hour), hibernating, next)
+	}
+
+	hibernating, reason, _, err := hibernationState(spec, now.Add(-3*time.Hour), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hibernating || reason != "IdleTimeout" {
+		t.Fatalf("expected to hibernate for idle timeout, got hibernating=%v reason=%s", hibernating, reason)
+	}
+}
+
+func TestHibernationStateInvalidSchedule(t *testing.T) {
+	spec := &v1alpha1.ProjectHibernationSpec{
+		Schedules: []v1alpha1.HibernationSchedule{{Sleep: "at night", Wake: "0 8 * * *"}},
+	}
+	if _, _, _, err := hibernationState(spec, time.Now(), time.Now()); err == nil {
+		t.Fatal("expected invalid schedule to be rejected")
+	}
+}
@@ -0,0 +1,213 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	clusterscope "github.com/launchboxio/operator/internal/scope/cluster"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+)
+
+const (
+	// defaultIngressNamespace is used when the cluster
+	// doesn't set the ingress controller namespace
+	defaultIngressNamespace = "ingress-nginx"
+
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+)
+
+// managedNetworkPolicies lists every NetworkPolicy the operator may
+// create in a project namespace, so unused policies can be removed
+var managedNetworkPolicies = []string{
+	"launchbox-default-deny",
+	"launchbox-allow-namespace",
+	"launchbox-allow-dns",
+	"launchbox-allow-apiserver",
+	"launchbox-allow-ingress",
+	"launchbox-allow-agent",
+	"launchbox-allow-egress",
+	"launchbox-allow-peers",
+}
+
+// reconcileNetworkPolicies isolates the project namespace, denying all
+// traffic besides the allowances needed by the vcluster, and the
+// additional rules of the project network settings
+func (scope *Scope) reconcileNetworkPolicies(ctx context.Context) error {
+	// The vcluster syncer talks to the host API server, which can only be
+	// selected by the addresses behind the kubernetes service
+	apiServer := &v1.Endpoints{}
+	if err := scope.Client.Get(ctx, types.NamespacedName{Name: "kubernetes", Namespace: "default"}, apiServer); err != nil {
+		return err
+	}
+
+	desired := networkPoliciesForProject(scope.Project, scope.Cluster, apiServer)
+
+	for _, name := range managedNetworkPolicies {
+		policy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: scope.Project.Spec.Slug}}
+		spec, ok := desired[name]
+		if !ok {
+			if err := scope.deleteIfExists(ctx, policy); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, err := controllerutil.CreateOrUpdate(ctx, scope.Client, policy, func() error {
+			if policy.Labels == nil {
+				policy.Labels = map[string]string{}
+			}
+			policy.Labels["app.kubernetes.io/managed-by"] = "launchboxhq"
+			policy.Spec = spec
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if result != controllerutil.OperationResultNone {
+			scope.Logger.Info(fmt.Sprintf("Network policy %s %s", name, result))
+		}
+	}
+
+	scope.markTrue(v1alpha1.ProjectNetworkReady, "Isolated", fmt.Sprintf("%d network policies are in sync", len(desired)))
+	return nil
+}
+
+// networkPoliciesForProject computes the NetworkPolicies of a project
+// namespace, keyed by name. Policies are additive, so every allowance
+// is a separate policy on top of the default deny
+func networkPoliciesForProject(project *v1alpha1.Project, cluster *v1alpha1.Cluster, apiServer *v1.Endpoints) map[string]networkingv1.NetworkPolicySpec {
+	allPods := metav1.LabelSelector{}
+	ingressNamespace := cluster.Spec.Ingress.Namespace
+	if ingressNamespace == "" {
+		ingressNamespace = defaultIngressNamespace
+	}
+
+	policies := map[string]networkingv1.NetworkPolicySpec{
+		"launchbox-default-deny": {
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+		"launchbox-allow-namespace": {
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &allPods}},
+			}},
+			Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{PodSelector: &allPods}},
+			}},
+		},
+		"launchbox-allow-dns": {
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{
+					NamespaceSelector: namespaceSelector("kube-system"),
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+					},
+				}},
+				Ports: []networkingv1.NetworkPolicyPort{
+					policyPort(v1.ProtocolUDP, 53),
+					policyPort(v1.ProtocolTCP, 53),
+				},
+			}},
+		},
+		"launchbox-allow-apiserver": {
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      apiServerEgress(apiServer),
+		},
+		"launchbox-allow-ingress": {
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: namespaceSelector(ingressNamespace)}},
+			}},
+		},
+		"launchbox-allow-agent": {
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: namespaceSelector(clusterscope.AgentNamespace)}},
+			}},
+		},
+	}
+
+	network := project.Spec.Network
+	if network == nil {
+		return policies
+	}
+
+	if len(network.Egress) > 0 {
+		policies["launchbox-allow-egress"] = networkingv1.NetworkPolicySpec{
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      network.Egress,
+		}
+	}
+
+	if len(network.Peers) > 0 {
+		peers := []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      namespaceNameLabel,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   network.Peers,
+				}},
+			},
+		}}
+		policies["launchbox-allow-peers"] = networkingv1.NetworkPolicySpec{
+			PodSelector: allPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: peers}},
+			Egress:      []networkingv1.NetworkPolicyEgressRule{{To: peers}},
+		}
+	}
+	return policies
+}
+
+// apiServerEgress allows traffic to every address and
+// port behind the kubernetes service
+func apiServerEgress(apiServer *v1.Endpoints) []networkingv1.NetworkPolicyEgressRule {
+	var rules []networkingv1.NetworkPolicyEgressRule
+	for _, subset := range apiServer.Subsets {
+		rule := networkingv1.NetworkPolicyEgressRule{}
+		for _, address := range subset.Addresses {
+			rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: hostCIDR(address.IP)},
+			})
+		}
+		for _, port := range subset.Ports {
+			rule.Ports = append(rule.Ports, policyPort(port.Protocol, port.Port))
+		}
+		if len(rule.To) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func hostCIDR(ip string) string {
+	if strings.Contains(ip, ":") {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
+
+func namespaceSelector(name string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{namespaceNameLabel: name},
+	}
+}
+
+func policyPort(protocol v1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	target := intstr.FromInt32(port)
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &target}
+}
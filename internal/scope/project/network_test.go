@@ -0,0 +1,74 @@
+package project
+
+import (
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+func testApiServer() *v1.Endpoints {
+	return &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "172.18.0.2"}, {IP: "fd00::2"}},
+			Ports:     []v1.EndpointPort{{Name: "https", Port: 6443, Protocol: v1.ProtocolTCP}},
+		}},
+	}
+}
+
+func TestNetworkPoliciesForProject(t *testing.T) {
+	project := &v1alpha1.Project{Spec: v1alpha1.ProjectSpec{Slug: "testing"}}
+	policies := networkPoliciesForProject(project, &v1alpha1.Cluster{}, testApiServer())
+
+	if len(policies) != 6 {
+		t.Fatalf("expected 6 policies, got %d", len(policies))
+	}
+	if _, ok := policies["launchbox-allow-peers"]; ok {
+		t.Fatalf("expected no peering policy")
+	}
+
+	ingress := policies["launchbox-allow-ingress"].Ingress[0].From[0].NamespaceSelector
+	if ingress.MatchLabels[namespaceNameLabel] != defaultIngressNamespace {
+		t.Fatalf("expected ingress from %s, got %v", defaultIngressNamespace, ingress.MatchLabels)
+	}
+
+	apiServer := policies["launchbox-allow-apiserver"].Egress
+	if len(apiServer) != 1 || len(apiServer[0].To) != 2 {
+		t.Fatalf("expected egress to both API server addresses, got %+v", apiServer)
+	}
+	if apiServer[0].To[0].IPBlock.CIDR != "172.18.0.2/32" || apiServer[0].To[1].IPBlock.CIDR != "fd00::2/128" {
+		t.Fatalf("unexpected API server addresses: %+v", apiServer[0].To)
+	}
+	if apiServer[0].Ports[0].Port.IntVal != 6443 {
+		t.Fatalf("expected egress on port 6443, got %v", apiServer[0].Ports[0].Port)
+	}
+}
+
+func TestNetworkPoliciesForProjectWithNetwork(t *testing.T) {
+	project := &v1alpha1.Project{Spec: v1alpha1.ProjectSpec{
+		Slug: "testing",
+		Network: &v1alpha1.ProjectNetworkSpec{
+			Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0"}}},
+			}},
+			Peers: []string{"staging"},
+		},
+	}}
+	cluster := &v1alpha1.Cluster{Spec: v1alpha1.ClusterSpec{
+		Ingress: v1alpha1.ClusterIngressSpec{Namespace: "traefik"},
+	}}
+	policies := networkPoliciesForProject(project, cluster, testApiServer())
+
+	if len(policies) != 8 {
+		t.Fatalf("expected 8 policies, got %d", len(policies))
+	}
+	peers := policies["launchbox-allow-peers"].Ingress[0].From[0].NamespaceSelector.MatchExpressions[0]
+	if len(peers.Values) != 1 || peers.Values[0] != "staging" {
+		t.Fatalf("expected ingress from the staging project, got %v", peers.Values)
+	}
+	ingress := policies["launchbox-allow-ingress"].Ingress[0].From[0].NamespaceSelector
+	if ingress.MatchLabels[namespaceNameLabel] != "traefik" {
+		t.Fatalf("expected ingress from traefik, got %v", ingress.MatchLabels)
+	}
+}
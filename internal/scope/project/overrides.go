@@ -0,0 +1,193 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// ownedValues are the values rendered by the operator, which overrides
+// can't change. Setting any parent of these keys is refused as well
+var ownedValues = []string{
+	"api.image",
+	"api.extraArgs",
+	"controller.image",
+	"scheduler.image",
+	"etcd.image",
+	"vcluster.image",
+	"vcluster.extraArgs",
+	"config",
+	"ingress.host",
+	"ingress.ingressClassName",
+	"init.manifests",
+}
+
+// appendedValues are lists that overrides append to,
+// rather than replacing the rendered list
+var appendedValues = []string{
+	"syncer.extraArgs",
+}
+
+// ownedArgs are the flags the operator passes in appended lists. Later
+// flags would win over the operator's, so overrides can't pass them
+var ownedArgs = []struct {
+	path  string
+	flags []string
+}{
+	{"syncer.extraArgs", []string{"--tls-san", "--out-kube-config-server"}},
+}
+
+// mergeOverrides merges the VclusterValues of the cluster, followed by
+// the ValuesOverrides of the project, over the rendered values. The
+// rendered values are returned unchanged if there are no overrides
+func (scope *Scope) mergeOverrides(ctx context.Context, rendered string) (string, error) {
+	if len(scope.Cluster.Spec.VclusterValues) == 0 && len(scope.Project.Spec.ValuesOverrides) == 0 {
+		return rendered, nil
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(rendered), &values); err != nil {
+		return "", err
+	}
+
+	for i, source := range scope.Cluster.Spec.VclusterValues {
+		overrides, err := scope.loadValues(ctx, scope.Cluster.Namespace, source)
+		if err != nil {
+			return "", fmt.Errorf("cluster vclusterValues[%d]: %w", i, err)
+		}
+		mergeValues(values, overrides, "")
+	}
+	for i, source := range scope.Project.Spec.ValuesOverrides {
+		overrides, err := scope.loadValues(ctx, scope.Project.Namespace, source)
+		if err != nil {
+			return "", fmt.Errorf("valuesOverrides[%d]: %w", i, err)
+		}
+		mergeValues(values, overrides, "")
+	}
+
+	merged, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
+}
+
+// loadValues parses the values of a source, and validates
+// they don't change any of the values owned by the operator
+func (scope *Scope) loadValues(ctx context.Context, namespace string, source v1alpha1.ValuesSource) (map[string]interface{}, error) {
+	raw := source.Values
+	if ref := source.ConfigMapKeyRef; ref != nil {
+		configMap := &v1.ConfigMap{}
+		if err := scope.APIReader.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, configMap); err != nil {
+			if apierrors.IsNotFound(err) && ref.Optional != nil && *ref.Optional {
+				return nil, nil
+			}
+			return nil, err
+		}
+		data, ok := configMap.Data[ref.Key]
+		if !ok && (ref.Optional == nil || !*ref.Optional) {
+			return nil, fmt.Errorf("configmap %s has no %s key", ref.Name, ref.Key)
+		}
+		raw = data
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, err
+	}
+	if err := ValidateOverrides(values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ValidateOverrides refuses values setting any of the values owned by
+// the operator, or one of their parents, and appended arguments passing
+// the flags of the operator
+func ValidateOverrides(values map[string]interface{}) error {
+	for _, owned := range ownedValues {
+		if setsValue(values, strings.Split(owned, ".")) {
+			return fmt.Errorf("%s is managed by the operator and can't be overridden", owned)
+		}
+	}
+	for _, owned := range ownedArgs {
+		args, _ := lookupValue(values, strings.Split(owned.path, ".")).([]interface{})
+		for _, arg := range args {
+			arg, _ := arg.(string)
+			for _, flag := range owned.flags {
+				if arg == flag || strings.HasPrefix(arg, flag+"=") {
+					return fmt.Errorf("%s %s is managed by the operator and can't be overridden", owned.path, flag)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// lookupValue returns the value at path, or nil if it isn't set
+func lookupValue(values map[string]interface{}, path []string) interface{} {
+	value, ok := values[path[0]]
+	if !ok || len(path) == 1 {
+		return value
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookupValue(nested, path[1:])
+}
+
+func setsValue(values map[string]interface{}, path []string) bool {
+	value, ok := values[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		return true
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		// Replacing a parent with anything but a map
+		// would remove the owned value as well
+		return true
+	}
+	return setsValue(nested, path[1:])
+}
+
+// mergeValues deep merges overrides into values. Maps are merged
+// recursively, appended lists are concatenated, and any other
+// value is replaced
+func mergeValues(values map[string]interface{}, overrides map[string]interface{}, prefix string) {
+	for key, override := range overrides {
+		path := prefix + key
+
+		if overrideMap, ok := override.(map[string]interface{}); ok {
+			if existing, ok := values[key].(map[string]interface{}); ok {
+				mergeValues(existing, overrideMap, path+".")
+				continue
+			}
+		}
+
+		if overrideList, ok := override.([]interface{}); ok && isAppended(path) {
+			if existing, ok := values[key].([]interface{}); ok {
+				values[key] = append(existing, overrideList...)
+				continue
+			}
+		}
+		values[key] = override
+	}
+}
+
+func isAppended(path string) bool {
+	for _, appended := range appendedValues {
+		if path == appended {
+			return true
+		}
+	}
+	return false
+}
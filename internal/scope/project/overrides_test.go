@@ -0,0 +1,110 @@
+package project
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const renderedValues = `
+sync:
+  ingresses:
+    enabled: true
+syncer:
+  extraArgs:
+    - --tls-san=testing.testing
+ingress:
+  enabled: true
+  host: api.testing.launchboxhq.dev
+`
+
+func TestMergeOverridesPrecedence(t *testing.T) {
+	project := testProject()
+	project.Spec.ValuesOverrides = []v1alpha1.ValuesSource{{
+		ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: "overrides"},
+			Key:                  "values.yaml",
+		},
+	}}
+	scope, _ := newTestScope(project, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "overrides", Namespace: "default"},
+		Data:       map[string]string{"values.yaml": "sync:\n  nodes:\n    enabled: false\n"},
+	})
+	scope.Cluster.Spec.VclusterValues = []v1alpha1.ValuesSource{{
+		Values: "sync:\n  persistentvolumes:\n    enabled: true\n  nodes:\n    enabled: true\nsyncer:\n  extraArgs:\n    - --sync-all-nodes\n",
+	}}
+
+	merged, err := scope.mergeOverrides(context.TODO(), renderedValues)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	values := struct {
+		Sync map[string]struct {
+			Enabled bool `json:"enabled"`
+		} `json:"sync"`
+		Syncer struct {
+			ExtraArgs []string `json:"extraArgs"`
+		} `json:"syncer"`
+		Ingress struct {
+			Host string `json:"host"`
+		} `json:"ingress"`
+	}{}
+	if err := yaml.Unmarshal([]byte(merged), &values); err != nil {
+		t.Fatalf("failed parsing values: %v", err)
+	}
+
+	if !values.Sync["ingresses"].Enabled || !values.Sync["persistentvolumes"].Enabled {
+		t.Fatalf("expected rendered and cluster values to be merged: %v", values.Sync)
+	}
+	if values.Sync["nodes"].Enabled {
+		t.Fatal("expected project overrides to take precedence over cluster values")
+	}
+	if strings.Join(values.Syncer.ExtraArgs, " ") != "--tls-san=testing.testing --sync-all-nodes" {
+		t.Fatalf("expected syncer args to be appended, got %v", values.Syncer.ExtraArgs)
+	}
+	if values.Ingress.Host != "api.testing.launchboxhq.dev" {
+		t.Fatalf("expected the ingress host to be kept, got %q", values.Ingress.Host)
+	}
+}
+
+func TestMergeOverridesWithoutOverrides(t *testing.T) {
+	scope := &Scope{Project: &v1alpha1.Project{}, Cluster: &v1alpha1.Cluster{}}
+
+	merged, err := scope.mergeOverrides(context.TODO(), renderedValues)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if merged != renderedValues {
+		t.Fatal("expected rendered values to be left untouched")
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	for _, tc := range []struct {
+		values string
+		valid  bool
+	}{
+		{"sync:\n  nodes:\n    enabled: true", true},
+		{"ingress:\n  annotations:\n    foo: bar", true},
+		{"syncer:\n  extraArgs: [--sync-all-nodes]", true},
+		{"ingress:\n  host: evil.example.com", false},
+		{"ingress: null", false},
+		{"vcluster:\n  extraArgs: [--kube-apiserver-arg=--oidc-issuer-url=https://evil.example.com]", false},
+		{"init:\n  manifests: ''", false},
+		{"syncer:\n  extraArgs: [--tls-san=evil.example.com]", false},
+		{"syncer:\n  extraArgs: [--out-kube-config-server, https://evil.example.com]", false},
+	} {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(tc.values), &values); err != nil {
+			t.Fatalf("failed parsing %q: %v", tc.values, err)
+		}
+		if err := ValidateOverrides(values); (err == nil) != tc.valid {
+			t.Fatalf("%q: expected valid to be %t, got error %v", tc.values, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcilePause scales the vcluster down while the project is paused, and
+// restores its previous replicas once resumed. Synced workloads are evicted
+// once the syncer has stopped, so the PodDisruptionBudgets synced from the
+// vcluster and the workloads' own termination grace periods are respected.
+// It returns true while workloads are still being drained
+func (scope *Scope) reconcilePause(ctx context.Context) (bool, error) {
+	identifier := scope.Project.Spec.Slug
+	statefulSet := &appsv1.StatefulSet{}
+	if err := scope.Client.Get(ctx, types.NamespacedName{
+		Name:      identifier,
+		Namespace: identifier,
+	}, statefulSet); err != nil {
+		scope.Logger.Error(err, "Failed querying statefulset")
+		return false, err
+	}
+
+	currentReplicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		currentReplicas = *statefulSet.Spec.Replicas
+	}
+
+	if !scope.isPaused() {
+		desiredReplicas := currentReplicas
+		if pause := scope.Project.Status.Pause; pause != nil {
+			desiredReplicas = pause.PreviousReplicas
+		} else if desiredReplicas == 0 {
+			desiredReplicas = 1
+		}
+		if err := scope.scaleStatefulSet(ctx, statefulSet, desiredReplicas); err != nil {
+			return false, err
+		}
+		scope.Project.Status.Pause = nil
+		scope.markFalse(v1alpha1.ProjectPaused, "Running", "vcluster is running")
+		return false, nil
+	}
+
+	pause := scope.Project.Status.Pause
+	if pause == nil {
+		previousReplicas := currentReplicas
+		if previousReplicas == 0 {
+			previousReplicas = 1
+		}
+		pause = &v1alpha1.ProjectPauseStatus{
+			PausedAt:         metav1.Now(),
+			PreviousReplicas: previousReplicas,
+		}
+		scope.Project.Status.Pause = pause
+	}
+
+	// Stop the syncer first, so evicted workloads aren't recreated
+	if err := scope.scaleStatefulSet(ctx, statefulSet, 0); err != nil {
+		return false, err
+	}
+	if pause.Drained {
+		scope.markTrue(v1alpha1.ProjectPaused, "ScaledDown", "vcluster has been scaled down")
+		return false, nil
+	}
+
+	remaining, blocked, err := scope.evictWorkloads(ctx)
+	if err != nil {
+		scope.Logger.Error(err, "Failed to evict running pods")
+		return false, err
+	}
+	if remaining > 0 {
+		message := fmt.Sprintf("Waiting for %d pods to terminate", remaining)
+		if blocked > 0 {
+			message = fmt.Sprintf("%s, %d blocked by disruption budgets", message, blocked)
+		}
+		scope.markFalse(v1alpha1.ProjectPaused, "Draining", message)
+		return true, nil
+	}
+
+	pause.Drained = true
+	scope.markTrue(v1alpha1.ProjectPaused, "ScaledDown", "vcluster has been scaled down")
+	return false, nil
+}
+
+func (scope *Scope) scaleStatefulSet(ctx context.Context, statefulSet *appsv1.StatefulSet, replicas int32) error {
+	if statefulSet.Spec.Replicas != nil && *statefulSet.Spec.Replicas == replicas {
+		return nil
+	}
+	scope.Logger.Info(fmt.Sprintf("Updating statefulset to %d replicas", replicas))
+	statefulSet.Spec.Replicas = &replicas
+	if err := scope.Client.Update(ctx, statefulSet); err != nil {
+		scope.Logger.Error(err, "Failed updating desired replicas")
+		return err
+	}
+	return nil
+}
+
+// evictWorkloads requests the eviction of every pod synced from the
+// vcluster, and returns how many pods still exist, along with how
+// many evictions were refused by a PodDisruptionBudget
+func (scope *Scope) evictWorkloads(ctx context.Context) (int, int, error) {
+	identifier := scope.Project.Spec.Slug
+	pods := &v1.PodList{}
+	if err := scope.Client.List(ctx, pods,
+		client.InNamespace(identifier),
+		client.MatchingLabels{"vcluster.loft.sh/managed-by": identifier},
+	); err != nil {
+		return 0, 0, err
+	}
+
+	blocked := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.GetDeletionTimestamp() != nil {
+			continue
+		}
+		err := scope.Client.SubResource("eviction").Create(ctx, pod, &policyv1.Eviction{})
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+		case apierrors.IsTooManyRequests(err):
+			blocked++
+		default:
+			return 0, 0, err
+		}
+	}
+	return len(pods.Items), blocked, nil
+}
@@ -0,0 +1,90 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newPauseScope(objs ...client.Object) *Scope {
+	project := testProject()
+	project.Spec.Paused = true
+	scope, _ := newTestScope(project, objs...)
+	return scope
+}
+
+func testStatefulSet(replicas int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "testing", Namespace: "testing"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+}
+
+func testReplicas(t *testing.T, scope *Scope) int32 {
+	statefulSet := &appsv1.StatefulSet{}
+	if err := scope.Client.Get(context.TODO(), types.NamespacedName{Name: "testing", Namespace: "testing"}, statefulSet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return *statefulSet.Spec.Replicas
+}
+
+func TestReconcilePauseDrainsAndRestores(t *testing.T) {
+	scope := newPauseScope(testStatefulSet(3), &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "workload-x-default-x-testing",
+			Namespace: "testing",
+			Labels:    map[string]string{"vcluster.loft.sh/managed-by": "testing"},
+		},
+	})
+
+	draining, err := scope.reconcilePause(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !draining {
+		t.Fatalf("expected to wait for the evicted pod")
+	}
+	if replicas := testReplicas(t, scope); replicas != 0 {
+		t.Fatalf("expected the vcluster to be scaled down, got %d replicas", replicas)
+	}
+	pause := scope.Project.Status.Pause
+	if pause == nil || pause.PreviousReplicas != 3 || pause.PausedAt.IsZero() {
+		t.Fatalf("expected the pre-pause state to be recorded, got %+v", pause)
+	}
+
+	draining, err = scope.reconcilePause(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if draining || !pause.Drained {
+		t.Fatalf("expected the project to be drained")
+	}
+
+	scope.Project.Spec.Paused = false
+	if _, err := scope.reconcilePause(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replicas := testReplicas(t, scope); replicas != 3 {
+		t.Fatalf("expected 3 replicas to be restored, got %d", replicas)
+	}
+	if scope.Project.Status.Pause != nil {
+		t.Fatalf("expected the pause state to be cleared")
+	}
+}
+
+func TestReconcilePauseResumesWithoutPauseState(t *testing.T) {
+	scope := newPauseScope(testStatefulSet(0))
+	scope.Project.Spec.Paused = false
+
+	if _, err := scope.reconcilePause(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replicas := testReplicas(t, scope); replicas != 1 {
+		t.Fatalf("expected 1 replica, got %d", replicas)
+	}
+}
@@ -3,12 +3,14 @@ package project
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/charts"
+	"github.com/launchboxio/operator/internal/helm"
+	"github.com/launchboxio/operator/internal/versions"
 	helmclient "github.com/mittwald/go-helm-client"
-	"helm.sh/helm/v3/pkg/repo"
-	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -19,6 +21,7 @@ import (
 	"k8s.io/client-go/dynamic"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"strings"
 	"time"
 )
@@ -27,26 +30,80 @@ type Scope struct {
 	Project       *v1alpha1.Project
 	Logger        logr.Logger
 	Client        client.Client
-	DynamicClient *dynamic.DynamicClient
+	DynamicClient dynamic.Interface
 	Cluster       *v1alpha1.Cluster
+
+	// Catalog resolves the project's kubernetes version to images
+	Catalog versions.Catalog
+
+	// ClusterProjects are the projects deployed to the same Cluster,
+	// used to limit how many upgrade their chart at the same time
+	ClusterProjects []v1alpha1.Project
+
+	// APIReader reads the projects of the cluster uncached before
+	// claiming a chart rollout slot, as well as the Secrets and
+	// ConfigMaps the manager doesn't cache
+	APIReader client.Reader
+
+	// HelmClientFactory provides the Helm client managing
+	// the vcluster release in the project namespace
+	HelmClientFactory helm.ClientFactory
+
+	// VclusterClient returns a client of the vcluster API
+	// from the kubeconfig of its vc-<slug> secret
+	VclusterClient func(kubeconfig []byte) (client.Client, error)
+}
+
+const projectFinalizer = "core.launchboxhq.io/finalizer"
+
+// chartRolloutInterval is how often projects queued
+// for a chart upgrade check for a free rollout slot
+const chartRolloutInterval = 30 * time.Second
+
+// shorterRequeue returns the shorter of two requeue
+// delays, where zero means no requeue
+func shorterRequeue(current time.Duration, interval time.Duration) time.Duration {
+	if current == 0 || current > interval {
+		return interval
+	}
+	return current
+}
+
+// providerConfigResources are the Crossplane ProviderConfigs created
+// for each project, pointing at the vcluster kubeconfig secret
+var providerConfigResources = []schema.GroupVersionResource{
+	{Group: "helm.crossplane.io", Version: "v1beta1", Resource: "providerconfigs"},
+	{Group: "kubernetes.crossplane.io", Version: "v1alpha1", Resource: "providerconfigs"},
 }
 
 func (scope *Scope) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	original := scope.Project.Status.DeepCopy()
+
+	result, err := scope.reconcile(ctx)
+	scope.summarize(err)
+	if statusErr := scope.patchStatus(ctx, original); statusErr != nil && err == nil {
+		return ctrl.Result{}, statusErr
+	}
+	return result, err
+}
+
+func (scope *Scope) reconcile(ctx context.Context) (ctrl.Result, error) {
 	identifier := scope.Project.Spec.Slug
-	helmClient, err := helmclient.New(&helmclient.Options{
-		Namespace: identifier,
-	})
 
+	if !controllerutil.ContainsFinalizer(scope.Project, projectFinalizer) {
+		controllerutil.AddFinalizer(scope.Project, projectFinalizer)
+		if err := scope.Client.Update(ctx, scope.Project); err != nil {
+			scope.Logger.Error(err, "Failed adding project finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	hibernationRequeue, err := scope.reconcileHibernation(time.Now())
 	if err != nil {
+		scope.Logger.Error(err, "Failed evaluating hibernation schedule")
 		return ctrl.Result{}, err
 	}
 
-	// TODO: We should probably add this in initialization somewhere, not in each reconciliation loop
-	err = helmClient.AddOrUpdateChartRepo(repo.Entry{
-		Name: "loft-sh",
-		URL:  "https://charts.loft.sh",
-	})
-
 	//  Ensure our namespace is created
 	namespace := &v1.Namespace{}
 	if err := scope.Client.Get(ctx, types.NamespacedName{Name: identifier}, namespace); err != nil {
@@ -61,44 +118,133 @@ func (scope *Scope) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.R
 			// Create the namespace
 			if err = scope.Client.Create(ctx, ns); err != nil {
 				scope.Logger.Error(err, "Failed creating namespace")
+				scope.markFalse(v1alpha1.ProjectNamespaceReady, "CreateFailed", err.Error())
 				return ctrl.Result{}, err
 			}
+			scope.markFalse(v1alpha1.ProjectNamespaceReady, "Creating", "Namespace has been created")
 			return ctrl.Result{Requeue: true}, nil
 		}
 		scope.Logger.Error(err, "Failed lookup for namespace")
 		return ctrl.Result{}, err
 	}
+	scope.markTrue(v1alpha1.ProjectNamespaceReady, "Created", "Namespace exists")
+
+	if err := scope.reconcileQuota(ctx); err != nil {
+		scope.Logger.Error(err, "Failed reconciling resource quota")
+		scope.markFalse(v1alpha1.ProjectQuotaReady, "ReconcileFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	if err := scope.reconcileNetworkPolicies(ctx); err != nil {
+		scope.Logger.Error(err, "Failed reconciling network policies")
+		scope.markFalse(v1alpha1.ProjectNetworkReady, "ReconcileFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	distro := projectDistro(scope.Project)
+	release, err := scope.Catalog.Resolve(distro, scope.Project.Spec.KubernetesVersion)
+	if err != nil {
+		scope.Logger.Error(err, "Failed resolving kubernetes version")
+		scope.markTrue(v1alpha1.ProjectUnsupportedVersion, "NotInCatalog", err.Error())
+		// The version catalog is watched, so there's no need
+		// to retry until either the project or catalog change
+		return ctrl.Result{}, nil
+	}
+	scope.markFalse(v1alpha1.ProjectUnsupportedVersion, "InCatalog", fmt.Sprintf("Using %s %s", distro, release.Version))
+
+	release, waiting, err := scope.reconcileVersion(ctx, distro, release)
+	if err != nil {
+		scope.Logger.Error(err, "Failed reconciling kubernetes version")
+		return ctrl.Result{}, err
+	}
+	if waiting {
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
 
 	var values bytes.Buffer
-	if err := ValuesTemplate.Execute(&values, getValuesArgs(scope)); err != nil {
+	if err := ValuesTemplate.Execute(&values, getValuesArgs(scope, release)); err != nil {
 		scope.Logger.Error(err, "Failed generating vcluster values")
+		scope.markFalse(v1alpha1.ProjectHelmReleaseReady, "ValuesFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	mergedValues, err := scope.mergeOverrides(ctx, values.String())
+	if err != nil {
+		scope.Logger.Error(err, "Failed merging vcluster values overrides")
+		scope.markFalse(v1alpha1.ProjectHelmReleaseReady, "InvalidValues", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	source := scope.Cluster.Spec.Charts.Vcluster
+	if source == nil {
+		source = &charts.DefaultVclusterSource
+	}
+	chart, err := charts.Resolve(ctx, scope.APIReader, scope.Cluster.Namespace, source, chartForDistro[distro])
+	if err != nil {
+		scope.Logger.Error(err, "Failed resolving vcluster chart")
+		scope.markFalse(v1alpha1.ProjectHelmReleaseReady, "ChartUnavailable", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	chartVersion, queued, err := scope.chartVersion(ctx, chart)
+	if err != nil {
+		scope.Logger.Error(err, "Failed resolving vcluster chart version")
 		return ctrl.Result{}, err
 	}
 
 	chartSpec := &helmclient.ChartSpec{
 		ReleaseName: identifier,
-		ChartName:   "loft-sh/vcluster",
+		ChartName:   chart.Name,
+		Version:     chartVersion,
 		Namespace:   identifier,
-		Wait:        true,
-		ValuesYaml:  values.String(),
+		ValuesYaml:  mergedValues,
 		Timeout:     time.Minute * 1,
 	}
 
-	// TODO: Might not be most efficient, but we'll just always install or upgrade
-	_, err = helmClient.InstallOrUpgradeChart(context.TODO(), chartSpec, nil)
+	pending, err := scope.reconcileRelease(ctx, chart, chartSpec)
 	if err != nil {
 		scope.Logger.Error(err, "Failed to install / upgrade helm chart")
+		reason := "InstallFailed"
+		if errors.Is(err, errReleaseFailed) {
+			reason = "ReleaseFailed"
+		}
+		scope.markFalse(v1alpha1.ProjectHelmReleaseReady, reason, err.Error())
 		return ctrl.Result{}, err
 	}
+	if pending {
+		scope.markFalse(v1alpha1.ProjectHelmReleaseReady, "Pending", "Waiting for pending release operation")
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+	scope.markTrue(v1alpha1.ProjectHelmReleaseReady, "Installed", "vcluster release has been installed")
+
+	if err := scope.observeVersion(ctx, distro, release); err != nil {
+		scope.Logger.Error(err, "Failed observing kubernetes version")
+		return ctrl.Result{}, err
+	}
+	if err := scope.observeChartRollout(ctx, distro); err != nil {
+		scope.Logger.Error(err, "Failed observing vcluster chart rollout")
+		return ctrl.Result{}, err
+	}
+
+	// Queued projects check for a free rollout slot
+	// periodically, and upgrades check their rollout
+	requeueAfter := hibernationRequeue
+	if queued {
+		requeueAfter = shorterRequeue(requeueAfter, chartRolloutInterval)
+	}
+	if upgradeRequeue := scope.upgradeRequeue(); upgradeRequeue > 0 {
+		requeueAfter = shorterRequeue(requeueAfter, upgradeRequeue)
+	}
 
 	// TODO: Wait for the vcluster instance to be ready
 	secret := &v1.Secret{}
-	if err := scope.Client.Get(ctx, types.NamespacedName{
+	if err := scope.APIReader.Get(ctx, types.NamespacedName{
 		Name:      "vc-" + identifier,
 		Namespace: identifier,
 	}, secret); err != nil {
 		if apierrors.IsNotFound(err) {
 			scope.Logger.Info("Waiting for vcluster secret to be available")
+			scope.markFalse(v1alpha1.ProjectKubeconfigReady, "WaitingForSecret", "Waiting for vcluster secret to be available")
 			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
 		}
 		scope.Logger.Error(err, "Failed quering vcluster secret")
@@ -109,100 +255,66 @@ func (scope *Scope) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.R
 	if scope.Project.Status.CaCertificate != string(secret.Data["certificate-authority"]) {
 		scope.Logger.Info("Storing CA certificate for project")
 		scope.Project.Status.CaCertificate = string(secret.Data["certificate-authority"])
-		scope.Project.Status.Status = "provisioned"
-		if err := scope.Client.Status().Update(context.TODO(), scope.Project); err != nil {
-			scope.Logger.Error(err, "Failed updating project status")
-			return ctrl.Result{}, err
-		}
-		return ctrl.Result{Requeue: true}, nil
+	}
+	scope.markTrue(v1alpha1.ProjectKubeconfigReady, "Available", "vcluster kubeconfig secret is available")
+
+	if err := scope.pruneRoleBindings(ctx, secret, roleBindingsForUsers(scope.Project.Spec.Users)); err != nil {
+		scope.Logger.Error(err, "Failed pruning vcluster role bindings")
+		return ctrl.Result{}, err
 	}
 
 	// Install any necessary crossplane providers
 	// TODO: Support dynamic provisioning. For now, we just install Kubernetes and Helm
 	if err := scope.installProviders(ctx); err != nil {
 		scope.Logger.Error(err, "Failed creating provider resources")
+		scope.markFalse(v1alpha1.ProjectProvidersReady, "CreateFailed", err.Error())
 		return ctrl.Result{}, err
 	}
+	scope.markTrue(v1alpha1.ProjectProvidersReady, "Created", "Provider configs have been created")
 
 	// TODO: Install any subscribed addons
 	for _, addon := range scope.Project.Spec.Addons {
+		identifier := fmt.Sprintf("%s/%s", addon.AddonName, addonInstallationName(addon))
 		if err := scope.reconcileAddon(addon, scope.Project); err != nil {
+			scope.markFalse(v1alpha1.ProjectAddonsReady, "InstallFailed", fmt.Sprintf("Addon %s: %s", identifier, err))
 			return ctrl.Result{}, err
 		}
-		installationName := addon.InstallationName
-		if installationName == "" {
-			installationName = addon.AddonName
-		}
-		identifier := fmt.Sprintf("%s/%s", addon.AddonName, installationName)
 		addonStatus := scope.Project.GetAddonStatus(identifier)
+		gvr := addonResource(addon)
+		addonStatus.Claim = &v1alpha1.ProjectAddonClaimReference{
+			Group:    gvr.Group,
+			Version:  gvr.Version,
+			Resource: gvr.Resource,
+			Name:     addonInstallationName(addon),
+		}
 		meta.SetStatusCondition(&addonStatus.Conditions, metav1.Condition{
 			Type:    "Ready",
 			Status:  metav1.ConditionTrue,
 			Reason:  "Installed",
 			Message: "Addon has been installed",
 		})
-		if err := scope.Client.Status().Update(context.TODO(), scope.Project); err != nil {
-			return ctrl.Result{}, err
-		}
 	}
+	scope.markTrue(v1alpha1.ProjectAddonsReady, "Installed", fmt.Sprintf("%d addons have been installed", len(scope.Project.Spec.Addons)))
 
-	statefulSet := &appsv1.StatefulSet{}
-	if err := scope.Client.Get(ctx, types.NamespacedName{
-		Name:      identifier,
-		Namespace: identifier,
-	}, statefulSet); err != nil {
-		scope.Logger.Error(err, "Failed querying statefulset")
+	draining, err := scope.reconcilePause(ctx)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-
-	var desiredReplicas int32
-	if scope.Project.Spec.Paused == true {
-		desiredReplicas = 0
-	} else {
-		desiredReplicas = 1
+	if draining {
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 	}
-
-	if *statefulSet.Spec.Replicas != desiredReplicas {
-		scope.Logger.Info(fmt.Sprintf("Updating statefulset to %d replicas", desiredReplicas))
-		statefulSet.Spec.Replicas = &desiredReplicas
-		if err := scope.Client.Update(ctx, statefulSet); err != nil {
-			scope.Logger.Error(err, "Failed updating desired replicas")
-			return ctrl.Result{}, err
-		}
-	}
-
-	// If paused, we also need to terminate all the running pods
-	if scope.Project.Spec.Paused == true {
-		pod := &v1.Pod{}
-		if err := scope.Client.DeleteAllOf(ctx, pod, []client.DeleteAllOfOption{
-			client.InNamespace(identifier),
-			client.MatchingLabels{"vcluster.loft.sh/managed-by": identifier},
-			client.GracePeriodSeconds(5),
-		}...); err != nil {
-			scope.Logger.Error(err, "Failed to delete running pods")
-			return ctrl.Result{}, err
-		}
-	}
-	// TODO: We shouldn't manually requeue. Instead, we should fix the generation
-	// observation to start execution on changes
-	return ctrl.Result{RequeueAfter: time.Minute * 1}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-func getValuesArgs(scope *Scope) ValuesTemplateArgs {
+func getValuesArgs(scope *Scope, release *versions.Release) ValuesTemplateArgs {
 	project := scope.Project
-	image := ImageMapping["1.28.3"]
-	if scope.Project.Spec.KubernetesVersion != "" {
-		if val, ok := ImageMapping[scope.Project.Spec.KubernetesVersion]; ok {
-			image = val
-		}
-	}
 	args := ValuesTemplateArgs{
-		ProjectId:   project.Spec.Id,
-		ProjectSlug: project.Spec.Slug,
-		Cpu:         project.Spec.Resources.Cpu,
-		Memory:      project.Spec.Resources.Memory,
-		Disk:        project.Spec.Resources.Disk,
-		Users:       project.Spec.Users,
+		ProjectId:    project.Spec.Id,
+		ProjectSlug:  project.Spec.Slug,
+		Cpu:          project.Spec.Resources.Cpu,
+		Memory:       project.Spec.Resources.Memory,
+		Disk:         project.Spec.Resources.Disk,
+		RoleBindings: roleBindingsForUsers(project.Spec.Users),
 		Oidc: struct {
 			ClientId  string
 			IssuerUrl string
@@ -216,17 +328,19 @@ func getValuesArgs(scope *Scope) ValuesTemplateArgs {
 			ClassName: scope.Cluster.Spec.Ingress.ClassName,
 			Domain:    scope.Cluster.Spec.Ingress.Domain,
 		},
-		Image: image,
+		Distro:  projectDistro(project),
+		Release: *release,
 	}
 	return args
 }
 
+func (scope *Scope) helmClient(opts helm.Options) (helmclient.Client, error) {
+	return scope.HelmClientFactory.ForNamespace(scope.Project.Spec.Slug, opts)
+}
+
 func (scope *Scope) installProviders(ctx context.Context) error {
 
-	for _, provider := range []schema.GroupVersionResource{
-		{Group: "helm.crossplane.io", Version: "v1beta1", Resource: "providerconfigs"},
-		{Group: "kubernetes.crossplane.io", Version: "v1alpha1", Resource: "providerconfigs"},
-	} {
+	for _, provider := range providerConfigResources {
 		scope.Logger.Info("Creating provider " + provider.Group + "/" + provider.Version)
 		providerConfig := &unstructured.Unstructured{
 			Object: map[string]interface{}{
@@ -264,41 +378,68 @@ func (scope *Scope) installProviders(ctx context.Context) error {
 }
 
 func (s *Scope) reconcileAddon(projectAddonSpec v1alpha1.ProjectAddonSpec, project *v1alpha1.Project) error {
-	name := projectAddonSpec.AddonName
-	if projectAddonSpec.InstallationName != "" {
-		name = projectAddonSpec.InstallationName
-	}
-	gvr := schema.GroupVersionResource{
-		Group:    projectAddonSpec.Group,
-		Version:  projectAddonSpec.Version,
-		Resource: strings.ToLower(projectAddonSpec.Resource) + "s",
-	}
-	addon := &unstructured.Unstructured{
-		Object: map[string]interface{}{
-			"apiVersion": projectAddonSpec.Group + "/" + projectAddonSpec.Version,
-			"kind":       projectAddonSpec.Resource,
-			"metadata": map[string]interface{}{
-				"name":      name,
-				"namespace": project.Spec.Slug,
-			},
-			"spec": map[string]interface{}{
-				"providerConfigRef": project.Spec.Slug,
-			},
-		},
-	}
-	_, err := s.DynamicClient.Resource(gvr).Namespace(project.Spec.Slug).Get(context.TODO(), name, metav1.GetOptions{})
+	name := addonInstallationName(projectAddonSpec)
+	resource := s.DynamicClient.Resource(addonResource(projectAddonSpec)).Namespace(project.Spec.Slug)
+	existing, err := resource.Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			_, err := s.DynamicClient.Resource(gvr).Namespace(project.Spec.Slug).Create(context.TODO(), addon, metav1.CreateOptions{})
+			addon := &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"apiVersion": projectAddonSpec.Group + "/" + projectAddonSpec.Version,
+					"kind":       projectAddonSpec.Resource,
+					"metadata": map[string]interface{}{
+						"name":      name,
+						"namespace": project.Spec.Slug,
+					},
+					"spec": map[string]interface{}{
+						"providerConfigRef": project.Spec.Slug,
+					},
+				},
+			}
+			_, err := resource.Create(context.TODO(), addon, metav1.CreateOptions{})
 			return err
 		}
 		return err
 	}
-	// TODO: Rather than always update, we should only update if needed
-	_, err = s.DynamicClient.Resource(gvr).Namespace(project.Spec.Slug).Update(context.TODO(), addon, metav1.UpdateOptions{})
+
+	// Claims are only updated when their provider changed, keeping
+	// the rest of the claim spec as the composition left it
+	providerConfigRef, _, _ := unstructured.NestedString(existing.Object, "spec", "providerConfigRef")
+	if providerConfigRef == project.Spec.Slug {
+		return nil
+	}
+	if err := unstructured.SetNestedField(existing.Object, project.Spec.Slug, "spec", "providerConfigRef"); err != nil {
+		return err
+	}
+	_, err = resource.Update(context.TODO(), existing, metav1.UpdateOptions{})
 	return err
 }
 
+// projectDistro returns the distro of the project, defaulting to k3s
+func projectDistro(project *v1alpha1.Project) versions.Distro {
+	if project.Spec.Distro == "" {
+		return versions.DistroK3s
+	}
+	return versions.Distro(project.Spec.Distro)
+}
+
+// addonInstallationName returns the name of the claim created
+// for an addon, defaulting to the addon name
+func addonInstallationName(projectAddonSpec v1alpha1.ProjectAddonSpec) string {
+	if projectAddonSpec.InstallationName != "" {
+		return projectAddonSpec.InstallationName
+	}
+	return projectAddonSpec.AddonName
+}
+
+func addonResource(projectAddonSpec v1alpha1.ProjectAddonSpec) schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    projectAddonSpec.Group,
+		Version:  projectAddonSpec.Version,
+		Resource: strings.ToLower(projectAddonSpec.Resource) + "s",
+	}
+}
+
 func isReleaseNotFoundError(err error) bool {
 	return err.Error() == "release: not found"
 }
@@ -0,0 +1,58 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestReconcileAddonSkipsUnchangedClaims(t *testing.T) {
+	claim := testClaim("database")
+	claim.Object["spec"] = map[string]interface{}{"providerConfigRef": "testing", "size": "small"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		testClaimResource: "PostgresInstanceList",
+	}, claim)
+	scope := &Scope{DynamicClient: dynamicClient}
+	project := &v1alpha1.Project{Spec: v1alpha1.ProjectSpec{Slug: "testing"}}
+	addon := v1alpha1.ProjectAddonSpec{
+		AddonName:        "postgres",
+		InstallationName: "database",
+		Group:            testClaimResource.Group,
+		Version:          testClaimResource.Version,
+		Resource:         "PostgresInstance",
+	}
+
+	if err := scope.reconcileAddon(addon, project); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() != "get" {
+			t.Fatalf("expected the unchanged claim not to be written, got %s", action.GetVerb())
+		}
+	}
+
+	// Claims of another provider are moved, keeping the rest of their spec
+	project.Spec.Slug = "renamed"
+	claim.SetNamespace("renamed")
+	if err := dynamicClient.Tracker().Create(testClaimResource, claim, "renamed"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scope.reconcileAddon(addon, project); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := dynamicClient.Resource(testClaimResource).Namespace("renamed").Get(context.TODO(), "database", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	providerConfigRef, _, _ := unstructured.NestedString(updated.Object, "spec", "providerConfigRef")
+	size, _, _ := unstructured.NestedString(updated.Object, "spec", "size")
+	if providerConfigRef != "renamed" || size != "small" {
+		t.Fatalf("expected the provider to be updated and the spec kept, got %v", updated.Object["spec"])
+	}
+}
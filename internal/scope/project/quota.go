@@ -0,0 +1,134 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// QuotaName is the name of the ResourceQuota and
+// LimitRange created in each project namespace
+const QuotaName = "launchbox"
+
+// reconcileQuota keeps the ResourceQuota and LimitRange of the project
+// namespace in sync with the project resources, and reports the usage
+// of the quota. Either is removed when no resources bound it
+func (scope *Scope) reconcileQuota(ctx context.Context) error {
+	resources := scope.Project.Spec.Resources
+	namespace := scope.Project.Spec.Slug
+
+	quota := &v1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: QuotaName, Namespace: namespace}}
+	hard := quotaForResources(resources)
+	if len(hard) == 0 {
+		if err := scope.deleteIfExists(ctx, quota); err != nil {
+			return err
+		}
+		scope.Project.Status.Quota = nil
+	} else {
+		result, err := controllerutil.CreateOrUpdate(ctx, scope.Client, quota, func() error {
+			quota.Spec.Hard = hard
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if result != controllerutil.OperationResultNone {
+			scope.Logger.Info(fmt.Sprintf("Resource quota %s", result))
+		}
+		scope.Project.Status.Quota = &v1alpha1.ProjectQuotaStatus{
+			Hard: quota.Status.Hard,
+			Used: quota.Status.Used,
+		}
+	}
+
+	limitRange := &v1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: QuotaName, Namespace: namespace}}
+	limits := limitsForResources(resources)
+	if len(limits) == 0 {
+		if err := scope.deleteIfExists(ctx, limitRange); err != nil {
+			return err
+		}
+	} else {
+		result, err := controllerutil.CreateOrUpdate(ctx, scope.Client, limitRange, func() error {
+			limitRange.Spec.Limits = limits
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if result != controllerutil.OperationResultNone {
+			scope.Logger.Info(fmt.Sprintf("Limit range %s", result))
+		}
+	}
+
+	scope.markTrue(v1alpha1.ProjectQuotaReady, "Synced", "Resource quota and limit range are in sync")
+	return nil
+}
+
+func (scope *Scope) deleteIfExists(ctx context.Context, obj client.Object) error {
+	if err := scope.Client.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// quotaForResources computes the hard limits of the project ResourceQuota.
+// The control plane allocation is added to the matching cpu, memory
+// and storage quotas, as the control plane shares the namespace
+func quotaForResources(resources v1alpha1.Resources) v1.ResourceList {
+	hard := v1.ResourceList{}
+	controlPlane := v1.ResourceList{
+		v1.ResourceCPU:     *resource.NewQuantity(int64(resources.Cpu), resource.DecimalSI),
+		v1.ResourceMemory:  *resource.NewQuantity(int64(resources.Memory)*1024*1024, resource.BinarySI),
+		v1.ResourceStorage: *resource.NewQuantity(int64(resources.Disk)*1024*1024*1024, resource.BinarySI),
+	}
+
+	for prefix, list := range map[string]v1.ResourceList{
+		"requests.": resources.Requests,
+		"limits.":   resources.Limits,
+	} {
+		for name, quantity := range list {
+			quantity = quantity.DeepCopy()
+			if allocated, ok := controlPlane[name]; ok {
+				// Storage can't be limited, only requested
+				if name == v1.ResourceStorage && prefix == "limits." {
+					continue
+				}
+				quantity.Add(allocated)
+			}
+			hard[v1.ResourceName(prefix+string(name))] = quantity
+		}
+	}
+
+	for name, count := range resources.Objects {
+		hard[v1.ResourceName(name)] = *resource.NewQuantity(count, resource.DecimalSI)
+	}
+
+	for _, class := range resources.StorageClasses {
+		prefix := class.Name + ".storageclass.storage.k8s.io/"
+		if class.Storage != nil {
+			hard[v1.ResourceName(prefix+string(v1.ResourceRequestsStorage))] = class.Storage.DeepCopy()
+		}
+		if class.PersistentVolumeClaims != nil {
+			hard[v1.ResourceName(prefix+string(v1.ResourcePersistentVolumeClaims))] = *resource.NewQuantity(*class.PersistentVolumeClaims, resource.DecimalSI)
+		}
+	}
+	return hard
+}
+
+// limitsForResources computes the container defaults of the project LimitRange
+func limitsForResources(resources v1alpha1.Resources) []v1.LimitRangeItem {
+	if len(resources.DefaultRequests) == 0 && len(resources.DefaultLimits) == 0 {
+		return nil
+	}
+	return []v1.LimitRangeItem{{
+		Type:           v1.LimitTypeContainer,
+		Default:        resources.DefaultLimits,
+		DefaultRequest: resources.DefaultRequests,
+	}}
+}
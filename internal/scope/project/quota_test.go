@@ -0,0 +1,60 @@
+package project
+
+import (
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQuotaForResources(t *testing.T) {
+	claims := int64(5)
+	premium := resource.MustParse("20Gi")
+	hard := quotaForResources(v1alpha1.Resources{
+		Cpu:    2,
+		Memory: 1024,
+		Disk:   10,
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:     resource.MustParse("4"),
+			v1.ResourceStorage: resource.MustParse("50Gi"),
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("16Gi"),
+		},
+		Objects: map[string]int64{"pods": 50},
+		StorageClasses: []v1alpha1.StorageClassQuota{
+			{Name: "premium", Storage: &premium, PersistentVolumeClaims: &claims},
+		},
+	})
+
+	for name, expected := range map[string]string{
+		"requests.cpu":     "6",
+		"requests.storage": "60Gi",
+		"limits.memory":    "17Gi",
+		"pods":             "50",
+		"premium.storageclass.storage.k8s.io/requests.storage":       "20Gi",
+		"premium.storageclass.storage.k8s.io/persistentvolumeclaims": "5",
+	} {
+		quantity, ok := hard[v1.ResourceName(name)]
+		if !ok {
+			t.Fatalf("expected %s to be limited", name)
+		}
+		if quantity.Cmp(resource.MustParse(expected)) != 0 {
+			t.Fatalf("expected %s to be %s, got %s", name, expected, quantity.String())
+		}
+	}
+	if len(hard) != 6 {
+		t.Fatalf("expected 6 limits, got %d", len(hard))
+	}
+}
+
+func TestQuotaForResourcesWithoutBounds(t *testing.T) {
+	resources := v1alpha1.Resources{Cpu: 2, Memory: 1024, Disk: 10}
+	if hard := quotaForResources(resources); len(hard) != 0 {
+		t.Fatalf("expected no quota, got %v", hard)
+	}
+	if limits := limitsForResources(resources); limits != nil {
+		t.Fatalf("expected no limit range, got %v", limits)
+	}
+}
@@ -0,0 +1,76 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/launchboxio/operator/internal/charts"
+	"github.com/launchboxio/operator/internal/conditions"
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// errReleaseFailed is returned when an install or upgrade leaves the
+// release failed, which needs its chart or values fixed rather than
+// another attempt
+var errReleaseFailed = errors.New("vcluster release failed")
+
+// reconcileRelease installs or upgrades the vcluster release. The
+// upgrade is skipped when the chart and rendered values match the last
+// successful install and the release is deployed. It returns true
+// when another Helm operation is still in progress for the release
+func (scope *Scope) reconcileRelease(ctx context.Context, chart *charts.Chart, chartSpec *helmclient.ChartSpec) (bool, error) {
+	helmClient, err := scope.helmClient(chart.Options)
+	if err != nil {
+		return false, err
+	}
+
+	rel, err := helmClient.GetRelease(chartSpec.ReleaseName)
+	if err != nil && !isReleaseNotFoundError(err) {
+		return false, err
+	}
+
+	hash := conditions.ReleaseHash(chartSpec)
+	if rel != nil && rel.Info != nil {
+		switch {
+		case rel.Info.Status.IsPending():
+			scope.Logger.Info("Waiting for pending release operation", "status", rel.Info.Status)
+			return true, nil
+		case rel.Info.Status == release.StatusDeployed && scope.Project.Status.ValuesHash == hash:
+			scope.recordChartVersion(rel)
+			return false, nil
+		case rel.Info.Status == release.StatusFailed && rel.Version == 1:
+			// A release that failed its first install has no deployed
+			// revision to upgrade from, so it has to be reinstalled
+			scope.Logger.Info("Removing failed release before reinstalling")
+			if err := helmClient.UninstallReleaseByName(chartSpec.ReleaseName); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if chart.Repository != nil {
+		if err := helmClient.AddOrUpdateChartRepo(*chart.Repository); err != nil {
+			return false, err
+		}
+	}
+
+	scope.Logger.Info("Installing or upgrading vcluster release")
+	rel, err = helmClient.InstallOrUpgradeChart(ctx, chartSpec, nil)
+	if err != nil {
+		if failed, getErr := helmClient.GetRelease(chartSpec.ReleaseName); getErr == nil && failed.Info != nil && failed.Info.Status == release.StatusFailed {
+			return false, fmt.Errorf("%w: %v", errReleaseFailed, err)
+		}
+		return false, err
+	}
+	scope.Project.Status.ValuesHash = hash
+	scope.recordChartVersion(rel)
+	return false, nil
+}
+
+// recordChartVersion reports the chart version of the vcluster release
+func (scope *Scope) recordChartVersion(rel *release.Release) {
+	if rel != nil && rel.Chart != nil && rel.Chart.Metadata != nil {
+		scope.Project.Status.VclusterChartVersion = rel.Chart.Metadata.Version
+	}
+}
@@ -0,0 +1,160 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/launchboxio/operator/internal/charts"
+	"github.com/launchboxio/operator/internal/conditions"
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+var testChart = &charts.Chart{
+	Name:       "loft-sh/vcluster",
+	Repository: &repo.Entry{Name: "loft-sh", URL: "https://charts.loft.sh"},
+}
+
+func testChartSpec(values string) *helmclient.ChartSpec {
+	return &helmclient.ChartSpec{
+		ReleaseName: "testing",
+		ChartName:   "loft-sh/vcluster",
+		Namespace:   "testing",
+		ValuesYaml:  values,
+	}
+}
+
+func TestReconcileReleaseInstallsMissingRelease(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+	chartSpec := testChartSpec("foo: bar")
+
+	pending, err := scope.reconcileRelease(context.TODO(), testChart, chartSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pending {
+		t.Fatal("expected release not to be pending")
+	}
+	if helm.Installs != 1 {
+		t.Fatalf("expected 1 install, got %d", helm.Installs)
+	}
+	if scope.Project.Status.ValuesHash != conditions.ReleaseHash(chartSpec) {
+		t.Fatal("expected values hash to be recorded")
+	}
+	if _, ok := helm.Repositories["loft-sh"]; !ok {
+		t.Fatal("expected the chart repository to be added")
+	}
+}
+
+func TestReconcileReleaseSkipsUnchangedRelease(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+	chartSpec := testChartSpec("foo: bar")
+
+	for i := 0; i < 3; i++ {
+		if _, err := scope.reconcileRelease(context.TODO(), testChart, chartSpec); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if helm.Installs != 1 {
+		t.Fatalf("expected 1 install, got %d", helm.Installs)
+	}
+}
+
+func TestReconcileReleaseUpgradesChangedValues(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+
+	if _, err := scope.reconcileRelease(context.TODO(), testChart, testChartSpec("foo: bar")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := scope.reconcileRelease(context.TODO(), testChart, testChartSpec("foo: baz")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Installs != 2 {
+		t.Fatalf("expected 2 installs, got %d", helm.Installs)
+	}
+	if helm.LastInstall.ValuesYaml != "foo: baz" {
+		t.Fatalf("expected upgrade with new values, got %q", helm.LastInstall.ValuesYaml)
+	}
+}
+
+func TestReconcileReleaseUpgradesFailedRelease(t *testing.T) {
+	chartSpec := testChartSpec("foo: bar")
+	scope, helm := newTestScope(testProject())
+	helm.Releases["testing"] = &release.Release{
+		Name:    "testing",
+		Version: 2,
+		Info:    &release.Info{Status: release.StatusFailed},
+	}
+	scope.Project.Status.ValuesHash = conditions.ReleaseHash(chartSpec)
+
+	if _, err := scope.reconcileRelease(context.TODO(), testChart, chartSpec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Installs != 1 || helm.Uninstalls != 0 {
+		t.Fatalf("expected an upgrade without uninstall, got %d installs and %d uninstalls", helm.Installs, helm.Uninstalls)
+	}
+}
+
+func TestReconcileReleaseReinstallsFailedFirstInstall(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+	helm.Releases["testing"] = &release.Release{
+		Name:    "testing",
+		Version: 1,
+		Info:    &release.Info{Status: release.StatusFailed},
+	}
+
+	if _, err := scope.reconcileRelease(context.TODO(), testChart, testChartSpec("foo: bar")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helm.Installs != 1 || helm.Uninstalls != 1 {
+		t.Fatalf("expected a reinstall, got %d installs and %d uninstalls", helm.Installs, helm.Uninstalls)
+	}
+}
+
+func TestReconcileReleaseWaitsForPendingRelease(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+	helm.Releases["testing"] = &release.Release{
+		Name:    "testing",
+		Version: 3,
+		Info:    &release.Info{Status: release.StatusPendingUpgrade},
+	}
+
+	pending, err := scope.reconcileRelease(context.TODO(), testChart, testChartSpec("foo: bar"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !pending {
+		t.Fatal("expected release to be pending")
+	}
+	if helm.Installs != 0 {
+		t.Fatalf("expected no installs, got %d", helm.Installs)
+	}
+}
+
+func TestReconcileReleaseKeepsHashOnFailure(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+	helm.InstallErr = errors.New("timed out")
+
+	if _, err := scope.reconcileRelease(context.TODO(), testChart, testChartSpec("foo: bar")); err == nil {
+		t.Fatal("expected install error")
+	}
+	if scope.Project.Status.ValuesHash != "" {
+		t.Fatal("expected values hash not to be recorded")
+	}
+}
+
+func TestReconcileReleaseReportsFailedRelease(t *testing.T) {
+	scope, helm := newTestScope(testProject())
+	helm.Releases["testing"] = &release.Release{
+		Name:    "testing",
+		Version: 2,
+		Info:    &release.Info{Status: release.StatusFailed},
+	}
+	helm.InstallErr = errors.New("post-upgrade hooks failed")
+
+	if _, err := scope.reconcileRelease(context.TODO(), testChart, testChartSpec("foo: bar")); !errors.Is(err, errReleaseFailed) {
+		t.Fatalf("expected the release to be reported failed, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package project
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// legacyAdminBinding is the ClusterRoleBinding earlier versions
+// rendered, binding every project user to cluster-admin
+const legacyAdminBinding = "admins"
+
+// pruneRoleBindings deletes the ClusterRoleBindings of the vcluster that
+// aren't rendered anymore, since vcluster doesn't delete init manifests
+// removed from its values. These are the bindings of roles no user
+// references anymore, and the admins binding of earlier versions. It's
+// skipped once the status lists the rendered bindings, and while the
+// project is paused since the vcluster API isn't running
+func (scope *Scope) pruneRoleBindings(ctx context.Context, secret *v1.Secret, bindings []RoleBinding) error {
+	names := make([]string, len(bindings))
+	for i, binding := range bindings {
+		names[i] = binding.Name
+	}
+	if scope.isPaused() || reflect.DeepEqual(names, scope.Project.Status.RoleBindings) {
+		return nil
+	}
+
+	vclusterClient, err := scope.VclusterClient(secret.Data["config"])
+	if err != nil {
+		return err
+	}
+
+	existing := &rbacv1.ClusterRoleBindingList{}
+	if err := vclusterClient.List(ctx, existing, client.MatchingLabels{"app.kubernetes.io/managed-by": "launchboxhq"}); err != nil {
+		return err
+	}
+	rendered := map[string]bool{}
+	for _, name := range names {
+		rendered[name] = true
+	}
+	for i := range existing.Items {
+		binding := &existing.Items[i]
+		if rendered[binding.Name] {
+			continue
+		}
+		scope.Logger.Info("Deleting stale role binding", "binding", binding.Name)
+		if err := vclusterClient.Delete(ctx, binding); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	legacy := &rbacv1.ClusterRoleBinding{}
+	if err := vclusterClient.Get(ctx, types.NamespacedName{Name: legacyAdminBinding}, legacy); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+	} else if legacy.RoleRef.Name == "cluster-admin" && legacy.Labels["app.kubernetes.io/managed-by"] == "" {
+		scope.Logger.Info("Deleting legacy admin role binding")
+		if err := vclusterClient.Delete(ctx, legacy); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	scope.Project.Status.RoleBindings = names
+	return nil
+}
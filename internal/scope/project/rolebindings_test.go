@@ -0,0 +1,80 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testRoleBinding(name string, role string, labels map[string]string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: role, APIGroup: rbacv1.GroupName},
+	}
+}
+
+func TestPruneRoleBindings(t *testing.T) {
+	managed := map[string]string{"app.kubernetes.io/managed-by": "launchboxhq"}
+	vclusterClient := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(
+		testRoleBinding("launchbox:view", "view", managed),
+		testRoleBinding("launchbox:edit", "edit", managed),
+		testRoleBinding(legacyAdminBinding, "cluster-admin", nil),
+		testRoleBinding("platform", "cluster-admin", nil),
+	).Build()
+
+	connections := 0
+	scope, _ := newTestScope(testProject())
+	scope.VclusterClient = func(kubeconfig []byte) (client.Client, error) {
+		if string(kubeconfig) != "kubeconfig" {
+			t.Fatalf("unexpected kubeconfig %q", kubeconfig)
+		}
+		connections++
+		return vclusterClient, nil
+	}
+	secret := &v1.Secret{Data: map[string][]byte{"config": []byte("kubeconfig")}}
+	bindings := roleBindingsForUsers([]v1alpha1.ProjectUser{{Email: "jane@example.com", ClusterRole: "view"}})
+
+	for i := 0; i < 2; i++ {
+		if err := scope.pruneRoleBindings(context.TODO(), secret, bindings); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if connections != 1 {
+		t.Fatalf("expected unchanged bindings not to be pruned again, got %d connections", connections)
+	}
+
+	remaining := &rbacv1.ClusterRoleBindingList{}
+	if err := vclusterClient.List(context.TODO(), remaining); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := map[string]bool{}
+	for _, binding := range remaining.Items {
+		names[binding.Name] = true
+	}
+	if len(names) != 2 || !names["launchbox:view"] || !names["platform"] {
+		t.Fatalf("expected the stale and legacy bindings to be deleted, got %v", names)
+	}
+	if roleBindings := scope.Project.Status.RoleBindings; len(roleBindings) != 1 || roleBindings[0] != "launchbox:view" {
+		t.Fatalf("unexpected role bindings status %v", roleBindings)
+	}
+}
+
+func TestPruneRoleBindingsSkipsPausedProjects(t *testing.T) {
+	project := testProject()
+	project.Spec.Paused = true
+	scope, _ := newTestScope(project)
+	scope.VclusterClient = func(kubeconfig []byte) (client.Client, error) {
+		t.Fatal("expected the vcluster API not to be called while paused")
+		return nil, nil
+	}
+	if err := scope.pruneRoleBindings(context.TODO(), &v1.Secret{}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package project
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/helm/fake"
+	"github.com/launchboxio/operator/internal/versions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScope(project *v1alpha1.Project, objs ...client.Object) (*Scope, *fake.Client) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+
+	factory := fake.NewClientFactory()
+	c := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(append(objs, project)...).
+		WithStatusSubresource(project).
+		Build()
+	return &Scope{
+		Project:           project,
+		Logger:            logr.Discard(),
+		Client:            c,
+		Cluster:           &v1alpha1.Cluster{},
+		Catalog:           versions.Default,
+		APIReader:         c,
+		HelmClientFactory: factory,
+	}, factory.Client("testing")
+}
+
+func testProject() *v1alpha1.Project {
+	return &v1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "testing", Namespace: "default", UID: "testing"},
+		Spec:       v1alpha1.ProjectSpec{Slug: "testing"},
+	}
+}
@@ -0,0 +1,96 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/conditions"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"strings"
+)
+
+// readinessConditions must all be true for a project to be Ready
+var readinessConditions = []string{
+	v1alpha1.ProjectNamespaceReady,
+	v1alpha1.ProjectQuotaReady,
+	v1alpha1.ProjectNetworkReady,
+	v1alpha1.ProjectHelmReleaseReady,
+	v1alpha1.ProjectKubeconfigReady,
+	v1alpha1.ProjectProvidersReady,
+	v1alpha1.ProjectAddonsReady,
+}
+
+func (scope *Scope) markTrue(conditionType string, reason string, message string) {
+	conditions.MarkTrue(&scope.Project.Status.Conditions, scope.Project.Generation, conditionType, reason, message)
+}
+
+func (scope *Scope) markFalse(conditionType string, reason string, message string) {
+	conditions.MarkFalse(&scope.Project.Status.Conditions, scope.Project.Generation, conditionType, reason, message)
+}
+
+// summarize computes the Ready condition and phase of the project
+// from the individual step conditions. Projects only fail on errors
+// that retrying won't fix, such as an unsupported version or a failed
+// release, while other errors leave them Provisioning, or Degraded
+// once they were ready
+func (scope *Scope) summarize(reconcileErr error) {
+	status := &scope.Project.Status
+	wasReady := status.Phase == v1alpha1.ProjectPhaseReady || status.Phase == v1alpha1.ProjectPhaseDegraded
+
+	ready := true
+	for _, conditionType := range readinessConditions {
+		if !meta.IsStatusConditionTrue(status.Conditions, conditionType) {
+			ready = false
+			break
+		}
+	}
+
+	switch {
+	case meta.IsStatusConditionTrue(status.Conditions, v1alpha1.ProjectUnsupportedVersion):
+		status.Phase = v1alpha1.ProjectPhaseFailed
+		scope.markFalse(v1alpha1.ProjectReady, "UnsupportedVersion", meta.FindStatusCondition(status.Conditions, v1alpha1.ProjectUnsupportedVersion).Message)
+	case scope.isPaused() && meta.IsStatusConditionTrue(status.Conditions, v1alpha1.ProjectPaused):
+		status.Phase = v1alpha1.ProjectPhasePaused
+		scope.markFalse(v1alpha1.ProjectReady, "Paused", "Project is paused")
+	case errors.Is(reconcileErr, errReleaseFailed):
+		status.Phase = v1alpha1.ProjectPhaseFailed
+		scope.markFalse(v1alpha1.ProjectReady, "ReleaseFailed", reconcileErr.Error())
+	case reconcileErr != nil && wasReady:
+		status.Phase = v1alpha1.ProjectPhaseDegraded
+		scope.markFalse(v1alpha1.ProjectReady, "ReconcileFailed", reconcileErr.Error())
+	case reconcileErr != nil:
+		status.Phase = v1alpha1.ProjectPhaseProvisioning
+		scope.markFalse(v1alpha1.ProjectReady, "ReconcileFailed", reconcileErr.Error())
+	case ready:
+		status.Phase = v1alpha1.ProjectPhaseReady
+		scope.markTrue(v1alpha1.ProjectReady, "Provisioned", "Project has been provisioned")
+	case len(status.Conditions) == 0:
+		status.Phase = v1alpha1.ProjectPhasePending
+	default:
+		status.Phase = v1alpha1.ProjectPhaseProvisioning
+		scope.markFalse(v1alpha1.ProjectReady, "Provisioning", "Project is being provisioned")
+	}
+	status.ObservedGeneration = scope.Project.Generation
+}
+
+// deprecatedStatus derives the deprecated Status field from the phase,
+// reporting ready projects as provisioned like earlier versions did
+func deprecatedStatus(phase v1alpha1.ProjectPhase) string {
+	switch phase {
+	case v1alpha1.ProjectPhaseReady, v1alpha1.ProjectPhaseDegraded:
+		return "provisioned"
+	}
+	return strings.ToLower(string(phase))
+}
+
+// patchStatus writes the project status, along with the deprecated
+// Status derived from its phase, if it has changed from the status
+// the reconciliation started with
+func (scope *Scope) patchStatus(ctx context.Context, original *v1alpha1.ProjectStatus) error {
+	scope.Project.Status.Status = deprecatedStatus(scope.Project.Status.Phase)
+	if err := conditions.PatchStatus(ctx, scope.Client, scope.Project, original, &scope.Project.Status); err != nil {
+		scope.Logger.Error(err, "Failed updating project status")
+		return err
+	}
+	return nil
+}
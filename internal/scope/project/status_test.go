@@ -0,0 +1,50 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+func TestSummarizePhase(t *testing.T) {
+	transient := errors.New("connection refused")
+	for _, tc := range []struct {
+		name     string
+		previous v1alpha1.ProjectPhase
+		err      error
+		expected v1alpha1.ProjectPhase
+	}{
+		{"transient error while provisioning", v1alpha1.ProjectPhaseProvisioning, transient, v1alpha1.ProjectPhaseProvisioning},
+		{"transient error once ready", v1alpha1.ProjectPhaseReady, transient, v1alpha1.ProjectPhaseDegraded},
+		{"failed release", v1alpha1.ProjectPhaseReady, fmt.Errorf("%w: %v", errReleaseFailed, transient), v1alpha1.ProjectPhaseFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scope, _ := newTestScope(testProject())
+			scope.Project.Status.Phase = tc.previous
+
+			scope.summarize(tc.err)
+			if scope.Project.Status.Phase != tc.expected {
+				t.Fatalf("expected phase %s, got %s", tc.expected, scope.Project.Status.Phase)
+			}
+			if meta.IsStatusConditionTrue(scope.Project.Status.Conditions, v1alpha1.ProjectReady) {
+				t.Fatal("expected the project not to be ready")
+			}
+		})
+	}
+}
+
+func TestDeprecatedStatus(t *testing.T) {
+	for phase, expected := range map[v1alpha1.ProjectPhase]string{
+		v1alpha1.ProjectPhaseReady:        "provisioned",
+		v1alpha1.ProjectPhaseDegraded:     "provisioned",
+		v1alpha1.ProjectPhaseProvisioning: "provisioning",
+		"":                                "",
+	} {
+		if status := deprecatedStatus(phase); status != expected {
+			t.Fatalf("%s: expected status %q, got %q", phase, expected, status)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/versions"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"time"
+)
+
+// upgradeRolloutInterval is how often an upgrade rolling out checks the
+// control plane, since the API server Deployment of the vanilla k8s
+// distro isn't cached and its rollout doesn't trigger a reconciliation
+const upgradeRolloutInterval = 10 * time.Second
+
+var volumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+// reconcileVersion decides which release should be deployed. Downgrades and
+// upgrades skipping a minor version are refused, keeping the running version
+// deployed. Allowed upgrades first snapshot the vcluster data volume, when
+// the cluster has a snapshot class, before the new version is rolled out.
+// Upgrades in progress are finished before the version can change again,
+// unless they're reverted before rolling out.
+// It returns true while waiting on the snapshot
+func (scope *Scope) reconcileVersion(ctx context.Context, distro versions.Distro, target *versions.Release) (*versions.Release, bool, error) {
+	status := &scope.Project.Status
+	current := status.KubernetesVersion
+	if current == "" {
+		status.Upgrade = nil
+		scope.markFalse(v1alpha1.ProjectUpgradeBlocked, "Allowed", "")
+		return target, false, nil
+	}
+
+	running, err := scope.Catalog.Resolve(distro, current)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if upgrade := status.Upgrade; upgrade != nil {
+		switch {
+		case target.Version == upgrade.ToVersion:
+			scope.markFalse(v1alpha1.ProjectUpgradeBlocked, "Allowed", "")
+		case target.Version == current && upgrade.Phase == v1alpha1.ProjectUpgradeSnapshotting:
+			// The upgrade was reverted before anything was rolled out
+			scope.Logger.Info("Cancelling upgrade", "to", upgrade.ToVersion)
+			status.Upgrade = nil
+			scope.markFalse(v1alpha1.ProjectUpgrading, "Cancelled", "Upgrade was cancelled before rolling out")
+			scope.markFalse(v1alpha1.ProjectUpgradeBlocked, "Allowed", "")
+			return running, false, nil
+		default:
+			// Finish the upgrade in progress before changing the version again,
+			// since the control plane may already run the new version
+			scope.Logger.Info("Refusing kubernetes version change during upgrade", "to", target.Version, "upgrading", upgrade.ToVersion)
+			scope.markTrue(v1alpha1.ProjectUpgradeBlocked, "UpgradeInProgress",
+				fmt.Sprintf("Upgrade from %s to %s must complete before changing the version", upgrade.FromVersion, upgrade.ToVersion))
+		}
+		target, err = scope.Catalog.Resolve(distro, upgrade.ToVersion)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		if current == target.Version {
+			scope.markFalse(v1alpha1.ProjectUpgradeBlocked, "Allowed", "")
+			return target, false, nil
+		}
+		if reason, message := checkUpgrade(current, target.Version); reason != "" {
+			scope.Logger.Info("Refusing kubernetes version change", "from", current, "to", target.Version, "reason", reason)
+			scope.markTrue(v1alpha1.ProjectUpgradeBlocked, reason, message)
+			return running, false, nil
+		}
+
+		scope.Logger.Info("Starting upgrade", "from", current, "to", target.Version)
+		status.Upgrade = &v1alpha1.ProjectUpgradeStatus{
+			FromVersion: current,
+			ToVersion:   target.Version,
+			Phase:       v1alpha1.ProjectUpgradeRollingOut,
+			StartedAt:   metav1.Now(),
+		}
+		if scope.Cluster.Spec.VolumeSnapshotClassName != "" {
+			status.Upgrade.Phase = v1alpha1.ProjectUpgradeSnapshotting
+		}
+		scope.markFalse(v1alpha1.ProjectUpgradeBlocked, "Allowed", "")
+	}
+
+	if status.Upgrade.Phase == v1alpha1.ProjectUpgradeSnapshotting {
+		ready, err := scope.snapshotData(ctx, distro)
+		if err != nil {
+			scope.markFalse(v1alpha1.ProjectUpgrading, "SnapshotFailed", err.Error())
+			return running, false, err
+		}
+		if !ready {
+			scope.markTrue(v1alpha1.ProjectUpgrading, "Snapshotting", fmt.Sprintf("Waiting for snapshot %s", status.Upgrade.Snapshot))
+			return running, true, nil
+		}
+		status.Upgrade.Phase = v1alpha1.ProjectUpgradeRollingOut
+	}
+
+	scope.markTrue(v1alpha1.ProjectUpgrading, "RollingOut", fmt.Sprintf("Upgrading from %s to %s", status.Upgrade.FromVersion, status.Upgrade.ToVersion))
+	return target, false, nil
+}
+
+// observeVersion records the version running in the vcluster
+// once the control plane has rolled out the deployed release
+func (scope *Scope) observeVersion(ctx context.Context, distro versions.Distro, deployed *versions.Release) error {
+	status := &scope.Project.Status
+	if status.KubernetesVersion == "" {
+		status.KubernetesVersion = deployed.Version
+		return nil
+	}
+	if status.Upgrade == nil || status.Upgrade.Phase != v1alpha1.ProjectUpgradeRollingOut {
+		return nil
+	}
+	// Paused control planes are scaled to zero, which would count as
+	// rolled out, so the upgrade completes once the project resumes
+	if scope.isPaused() {
+		return nil
+	}
+
+	rolledOut, err := scope.isRolledOut(ctx, distro)
+	if err != nil || !rolledOut {
+		return err
+	}
+
+	scope.Logger.Info("Upgrade completed", "from", status.Upgrade.FromVersion, "to", status.Upgrade.ToVersion)
+	scope.markFalse(v1alpha1.ProjectUpgrading, "Completed", fmt.Sprintf("Upgraded from %s to %s", status.Upgrade.FromVersion, status.Upgrade.ToVersion))
+	status.PreviousKubernetesVersion = status.Upgrade.FromVersion
+	status.KubernetesVersion = status.Upgrade.ToVersion
+	status.Upgrade = nil
+	return nil
+}
+
+// upgradeRequeue returns how long until an upgrade rolling out checks
+// the control plane again, or zero without one. Paused projects are
+// reconciled again when they resume
+func (scope *Scope) upgradeRequeue() time.Duration {
+	upgrade := scope.Project.Status.Upgrade
+	if upgrade == nil || upgrade.Phase != v1alpha1.ProjectUpgradeRollingOut || scope.isPaused() {
+		return 0
+	}
+	return upgradeRolloutInterval
+}
+
+// snapshotData creates a VolumeSnapshot of the vcluster data
+// volume, and returns true once it's ready to use
+func (scope *Scope) snapshotData(ctx context.Context, distro versions.Distro) (bool, error) {
+	upgrade := scope.Project.Status.Upgrade
+	slug := scope.Project.Spec.Slug
+	if upgrade.Snapshot == "" {
+		upgrade.Snapshot = fmt.Sprintf("%s-%s", slug, strings.ReplaceAll(upgrade.FromVersion, ".", "-"))
+	}
+
+	snapshots := scope.DynamicClient.Resource(volumeSnapshotResource).Namespace(slug)
+	snapshot, err := snapshots.Get(ctx, upgrade.Snapshot, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+
+		scope.Logger.Info("Creating data volume snapshot", "snapshot", upgrade.Snapshot)
+		_, err := snapshots.Create(ctx, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": volumeSnapshotResource.Group + "/" + volumeSnapshotResource.Version,
+				"kind":       "VolumeSnapshot",
+				"metadata": map[string]interface{}{
+					"name":      upgrade.Snapshot,
+					"namespace": slug,
+					"labels": map[string]interface{}{
+						"launchboxhq.io/kubernetes-version": upgrade.FromVersion,
+					},
+				},
+				"spec": map[string]interface{}{
+					"volumeSnapshotClassName": scope.Cluster.Spec.VolumeSnapshotClassName,
+					"source": map[string]interface{}{
+						"persistentVolumeClaimName": dataVolumeClaimName(slug, distro),
+					},
+				},
+			},
+		}, metav1.CreateOptions{})
+		return false, err
+	}
+
+	ready, _, err := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	return ready, err
+}
+
+// checkUpgrade returns the reason a version change is refused, if any
+func checkUpgrade(from string, to string) (string, string) {
+	fromVersion, err := semver.NewVersion(from)
+	if err != nil {
+		return "InvalidVersion", err.Error()
+	}
+	toVersion, err := semver.NewVersion(to)
+	if err != nil {
+		return "InvalidVersion", err.Error()
+	}
+
+	if toVersion.LessThan(fromVersion) {
+		return "Downgrade", fmt.Sprintf("Downgrading from %s to %s is not supported", from, to)
+	}
+	if toVersion.Major() != fromVersion.Major() || toVersion.Minor() > fromVersion.Minor()+1 {
+		return "MinorVersionSkew", fmt.Sprintf("Upgrading from %s to %s skips a minor version, upgrade one minor version at a time", from, to)
+	}
+	return "", ""
+}
+
+func dataVolumeClaimName(slug string, distro versions.Distro) string {
+	if distro == versions.DistroK8s {
+		return "data-" + slug + "-etcd-0"
+	}
+	return "data-" + slug + "-0"
+}
+
+// isRolledOut checks whether every replica of the vcluster control plane
+// runs the latest revision. The vanilla k8s distro runs the API server
+// as a Deployment, read uncached since only the agent Deployments are
+// cached, while the other distros use a single StatefulSet
+func (scope *Scope) isRolledOut(ctx context.Context, distro versions.Distro) (bool, error) {
+	slug := scope.Project.Spec.Slug
+	if distro == versions.DistroK8s {
+		deployment := &appsv1.Deployment{}
+		if err := scope.APIReader.Get(ctx, types.NamespacedName{Name: slug + "-api", Namespace: slug}, deployment); err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		return deployment.Status.ObservedGeneration >= deployment.Generation &&
+			deployment.Status.UpdatedReplicas == replicas &&
+			deployment.Status.AvailableReplicas == replicas, nil
+	}
+
+	statefulSet := &appsv1.StatefulSet{}
+	if err := scope.Client.Get(ctx, types.NamespacedName{Name: slug, Namespace: slug}, statefulSet); err != nil {
+		return false, err
+	}
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	return statefulSet.Status.ObservedGeneration >= statefulSet.Generation &&
+		statefulSet.Status.UpdateRevision == statefulSet.Status.CurrentRevision &&
+		statefulSet.Status.UpdatedReplicas == replicas &&
+		statefulSet.Status.ReadyReplicas == replicas, nil
+}
@@ -0,0 +1,184 @@
+package project
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/versions"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCheckUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		from   string
+		to     string
+		reason string
+	}{
+		{"1.27.3", "1.27.7", ""},
+		{"1.27.7", "1.28.3", ""},
+		{"1.27.7", "1.27.3", "Downgrade"},
+		{"1.28.3", "1.27.7", "Downgrade"},
+		{"1.26.10", "1.28.3", "MinorVersionSkew"},
+	} {
+		reason, _ := checkUpgrade(tc.from, tc.to)
+		if reason != tc.reason {
+			t.Fatalf("%s -> %s: expected reason %q, got %q", tc.from, tc.to, tc.reason, reason)
+		}
+	}
+}
+
+func newUpgradeScope(upgrade *v1alpha1.ProjectUpgradeStatus, objs ...client.Object) *Scope {
+	project := testProject()
+	project.Status = v1alpha1.ProjectStatus{KubernetesVersion: "1.27.3", Upgrade: upgrade}
+	scope, _ := newTestScope(project, objs...)
+	return scope
+}
+
+func testUpgrade(phase v1alpha1.ProjectUpgradePhase) *v1alpha1.ProjectUpgradeStatus {
+	return &v1alpha1.ProjectUpgradeStatus{FromVersion: "1.27.3", ToVersion: "1.27.7", Phase: phase}
+}
+
+func reconcileTestVersion(t *testing.T, scope *Scope, version string) string {
+	target, err := scope.Catalog.Resolve(versions.DistroK3s, version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployed, _, err := scope.reconcileVersion(context.TODO(), versions.DistroK3s, target)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return deployed.Version
+}
+
+func TestReconcileVersionStartsUpgrade(t *testing.T) {
+	scope := newUpgradeScope(nil)
+
+	if deployed := reconcileTestVersion(t, scope, "1.27.7"); deployed != "1.27.7" {
+		t.Fatalf("expected the new version to be deployed, got %s", deployed)
+	}
+	upgrade := scope.Project.Status.Upgrade
+	if upgrade == nil || upgrade.Phase != v1alpha1.ProjectUpgradeRollingOut || upgrade.ToVersion != "1.27.7" {
+		t.Fatalf("expected the upgrade to roll out, got %+v", upgrade)
+	}
+	if !meta.IsStatusConditionTrue(scope.Project.Status.Conditions, v1alpha1.ProjectUpgrading) {
+		t.Fatalf("expected the project to be upgrading")
+	}
+}
+
+func TestReconcileVersionCancelsSnapshottingUpgrade(t *testing.T) {
+	scope := newUpgradeScope(testUpgrade(v1alpha1.ProjectUpgradeSnapshotting))
+
+	if deployed := reconcileTestVersion(t, scope, "1.27.3"); deployed != "1.27.3" {
+		t.Fatalf("expected the running version to stay deployed, got %s", deployed)
+	}
+	if scope.Project.Status.Upgrade != nil {
+		t.Fatalf("expected the upgrade to be cancelled")
+	}
+	upgrading := meta.FindStatusCondition(scope.Project.Status.Conditions, v1alpha1.ProjectUpgrading)
+	if upgrading == nil || upgrading.Status != metav1.ConditionFalse || upgrading.Reason != "Cancelled" {
+		t.Fatalf("expected the upgrade to be reported cancelled, got %+v", upgrading)
+	}
+}
+
+func TestReconcileVersionFinishesRollingOutUpgrade(t *testing.T) {
+	// Reverting, or retargeting, an upgrade that's rolling out
+	// keeps rolling out the version of the upgrade
+	for _, version := range []string{"1.27.3", "1.28.3"} {
+		scope := newUpgradeScope(testUpgrade(v1alpha1.ProjectUpgradeRollingOut))
+
+		if deployed := reconcileTestVersion(t, scope, version); deployed != "1.27.7" {
+			t.Fatalf("%s: expected the upgrade to keep rolling out, got %s", version, deployed)
+		}
+		conditions := scope.Project.Status.Conditions
+		blocked := meta.FindStatusCondition(conditions, v1alpha1.ProjectUpgradeBlocked)
+		if blocked == nil || blocked.Status != metav1.ConditionTrue || blocked.Reason != "UpgradeInProgress" {
+			t.Fatalf("%s: expected the change to be blocked, got %+v", version, blocked)
+		}
+		if scope.Project.Status.Upgrade == nil || !meta.IsStatusConditionTrue(conditions, v1alpha1.ProjectUpgrading) {
+			t.Fatalf("%s: expected the upgrade to stay in progress", version)
+		}
+	}
+}
+
+func TestObserveVersionCompletesUpgrade(t *testing.T) {
+	statefulSet := testStatefulSet(1)
+	statefulSet.Status = appsv1.StatefulSetStatus{
+		UpdatedReplicas: 1,
+		ReadyReplicas:   1,
+		CurrentRevision: "testing-2",
+		UpdateRevision:  "testing-2",
+	}
+	scope := newUpgradeScope(testUpgrade(v1alpha1.ProjectUpgradeRollingOut), statefulSet)
+	deployed, _ := scope.Catalog.Resolve(versions.DistroK3s, "1.27.7")
+
+	if err := scope.observeVersion(context.TODO(), versions.DistroK3s, deployed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status := scope.Project.Status
+	if status.Upgrade != nil || status.KubernetesVersion != "1.27.7" || status.PreviousKubernetesVersion != "1.27.3" {
+		t.Fatalf("expected the upgrade to complete, got %+v", status)
+	}
+
+	// Reverting once the upgrade completed is a refused downgrade
+	if deployed := reconcileTestVersion(t, scope, "1.27.3"); deployed != "1.27.7" {
+		t.Fatalf("expected the upgraded version to stay deployed, got %s", deployed)
+	}
+	blocked := meta.FindStatusCondition(scope.Project.Status.Conditions, v1alpha1.ProjectUpgradeBlocked)
+	if blocked == nil || blocked.Reason != "Downgrade" {
+		t.Fatalf("expected the downgrade to be blocked, got %+v", blocked)
+	}
+}
+
+func TestObserveVersionWaitsWhilePaused(t *testing.T) {
+	// Paused control planes have no replicas to roll out
+	statefulSet := testStatefulSet(0)
+	scope := newUpgradeScope(testUpgrade(v1alpha1.ProjectUpgradeRollingOut), statefulSet)
+	scope.Project.Spec.Paused = true
+	deployed, _ := scope.Catalog.Resolve(versions.DistroK3s, "1.27.7")
+
+	if err := scope.observeVersion(context.TODO(), versions.DistroK3s, deployed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scope.Project.Status.Upgrade == nil || scope.Project.Status.KubernetesVersion != "1.27.3" {
+		t.Fatalf("expected the upgrade to wait until the project resumes")
+	}
+	if requeue := scope.upgradeRequeue(); requeue != 0 {
+		t.Fatalf("expected paused projects not to check the rollout, got %s", requeue)
+	}
+}
+
+func TestUpgradeRequeue(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		upgrade  *v1alpha1.ProjectUpgradeStatus
+		expected time.Duration
+	}{
+		{"no upgrade", nil, 0},
+		{"snapshotting", testUpgrade(v1alpha1.ProjectUpgradeSnapshotting), 0},
+		{"rolling out", testUpgrade(v1alpha1.ProjectUpgradeRollingOut), upgradeRolloutInterval},
+	} {
+		scope := newUpgradeScope(tc.upgrade)
+		if requeue := scope.upgradeRequeue(); requeue != tc.expected {
+			t.Fatalf("%s: expected a requeue after %s, got %s", tc.name, tc.expected, requeue)
+		}
+	}
+}
+
+func TestObserveVersionWaitsForRollout(t *testing.T) {
+	statefulSet := testStatefulSet(1)
+	statefulSet.Status = appsv1.StatefulSetStatus{CurrentRevision: "testing-1", UpdateRevision: "testing-2"}
+	scope := newUpgradeScope(testUpgrade(v1alpha1.ProjectUpgradeRollingOut), statefulSet)
+	deployed, _ := scope.Catalog.Resolve(versions.DistroK3s, "1.27.7")
+
+	if err := scope.observeVersion(context.TODO(), versions.DistroK3s, deployed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scope.Project.Status.Upgrade == nil || scope.Project.Status.KubernetesVersion != "1.27.3" {
+		t.Fatalf("expected the upgrade to wait for the rollout")
+	}
+}
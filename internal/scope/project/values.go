@@ -2,32 +2,17 @@ package project
 
 import (
 	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/versions"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sort"
 	"text/template"
 )
 
-var ImageMapping = map[string]string{
-	"1.28.3":  "rancher/k3s:v1.28.3-k3s2",
-	"1.28.2":  "rancher/k3s:v1.28.2-k3s1",
-	"1.28.1":  "rancher/k3s:v1.28.1-k3s1",
-	"1.28.0":  "rancher/k3s:v1.28.0-rc1-k3s1",
-	"1.27.7":  "rancher/k3s:v1.27.7-k3s2",
-	"1.27.6":  "rancher/k3s:v1.27.6-k3s1",
-	"1.27.5":  "rancher/k3s:v1.27.5-k3s1",
-	"1.27.4":  "rancher/k3s:v1.27.4-k3s1",
-	"1.27.3":  "rancher/k3s:v1.27.3-k3s1",
-	"1.27.2":  "rancher/k3s:v1.27.2-k3s1",
-	"1.27.1":  "rancher/k3s:v1.27.1-k3s1",
-	"1.26.10": "rancher/k3s:v1.26.10-k3s2",
-	"1.26.9":  "rancher/k3s:v1.26.9-k3s1",
-	"1.26.8":  "rancher/k3s:v1.26.8-k3s1",
-	"1.26.7":  "rancher/k3s:v1.26.8-k3s1",
-	"1.26.6":  "rancher/k3s:v1.26.6-k3s1",
-	"1.26.5":  "rancher/k3s:v1.26.5-k3s1",
-	"1.26.4":  "rancher/k3s:v1.26.4-k3s1",
-	"1.26.3":  "rancher/k3s:v1.26.3-k3s1",
-	"1.26.2":  "rancher/k3s:v1.26.2-k3s1",
-	"1.26.1":  "rancher/k3s:v1.26.1-k3s1",
-	"1.26.0":  "rancher/k3s:v1.26.0-k3s2",
+// chartForDistro maps each distro to its vcluster chart
+var chartForDistro = map[versions.Distro]string{
+	versions.DistroK3s: "vcluster",
+	versions.DistroK0s: "vcluster-k0s",
+	versions.DistroK8s: "vcluster-k8s",
 }
 
 type ValuesTemplateArgs struct {
@@ -44,15 +29,86 @@ type ValuesTemplateArgs struct {
 		ClassName string
 		Domain    string
 	}
-	Image string
-	Users []v1alpha1.ProjectUser
+	Distro       versions.Distro
+	Release      versions.Release
+	RoleBindings []RoleBinding
+}
+
+// RoleBinding is a ClusterRoleBinding rendered into the
+// vcluster, binding every subject of a single ClusterRole
+type RoleBinding struct {
+	Name        string
+	ClusterRole string
+	Subjects    []rbacv1.Subject
+}
+
+// roleBindingsForUsers groups project users by their ClusterRole. Bindings
+// are named after their role, and the binding of a role no users reference
+// anymore is deleted by pruneRoleBindings. The webhook refuses users with
+// both an email and a group, which are bound as the group otherwise
+func roleBindingsForUsers(users []v1alpha1.ProjectUser) []RoleBinding {
+	bindings := map[string]*RoleBinding{}
+	for _, user := range users {
+		subject := rbacv1.Subject{Kind: rbacv1.UserKind, Name: user.Email, APIGroup: rbacv1.GroupName}
+		if user.Group != "" {
+			subject = rbacv1.Subject{Kind: rbacv1.GroupKind, Name: user.Group, APIGroup: rbacv1.GroupName}
+		}
+		if subject.Name == "" || user.ClusterRole == "" {
+			continue
+		}
+
+		binding, ok := bindings[user.ClusterRole]
+		if !ok {
+			binding = &RoleBinding{
+				Name:        "launchbox:" + user.ClusterRole,
+				ClusterRole: user.ClusterRole,
+			}
+			bindings[user.ClusterRole] = binding
+		}
+		binding.Subjects = append(binding.Subjects, subject)
+	}
+
+	// Sort the bindings to keep the rendered values stable
+	result := make([]RoleBinding, 0, len(bindings))
+	for _, binding := range bindings {
+		result = append(result, *binding)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ClusterRole < result[j].ClusterRole
+	})
+	return result
 }
 
 var ValuesTemplate = template.Must(template.New("values").Parse(`
 globalAnnotations:
   "launchboxhq.io/project-id": "{{ .ProjectId }}"
+{{- if eq .Distro "k8s" }}
+api:
+  image: {{ .Release.ApiServer }}
+  {{- with .Oidc }}
+  extraArgs:
+    - "--oidc-issuer-url={{ .IssuerUrl }}"
+    - "--oidc-client-id={{ .ClientId }}"
+    - "--oidc-username-claim=email"
+    - "--oidc-groups-claim=groups"
+  {{- end }}
+  resources:
+    limits:
+      cpu: {{ .Cpu }}
+      memory: "{{ .Memory }}Mi"
+controller:
+  image: {{ .Release.ControllerManager }}
+scheduler:
+  image: {{ .Release.Scheduler }}
+etcd:
+  image: {{ .Release.Etcd }}
+  storage:
+    persistence: true
+    size: "{{ .Disk }}Gi"
+{{- else }}
 vcluster:
-  image: {{ .Image }}
+  image: {{ .Release.Image }}
+  {{- if eq .Distro "k3s" }}
   {{- with .Oidc }}
   extraArgs:
     - "--kube-apiserver-arg=--oidc-username-claim=preferred_username"
@@ -61,19 +117,51 @@ vcluster:
     - "--kube-apiserver-arg=--oidc-username-claim=email"
     - "--kube-apiserver-arg=--oidc-groups-claim=groups"
   {{- end }}
+  {{- end }}
   resources:
     limits:
       cpu: {{ .Cpu }}
       memory: "{{ .Memory }}Mi"
+{{- if eq .Distro "k0s" }}
+config: |-
+  apiVersion: k0s.k0sproject.io/v1beta1
+  kind: Cluster
+  metadata:
+    name: k0s
+  spec:
+    api:
+      port: 6443
+      k0sApiPort: 9443
+      extraArgs:
+        enable-admission-plugins: NodeRestriction
+        endpoint-reconciler-type: none
+        {{- with .Oidc }}
+        oidc-issuer-url: "{{ .IssuerUrl }}"
+        oidc-client-id: "{{ .ClientId }}"
+        oidc-username-claim: email
+        oidc-groups-claim: groups
+        {{- end }}
+    network:
+      # Will be replaced automatically by the syncer container on first startup
+      serviceCIDR: CIDR_PLACEHOLDER
+      provider: custom
+    controllerManager:
+      extraArgs:
+        controllers: '*,-nodeipam,-nodelifecycle,-persistentvolume-binder,-attachdetach,-persistentvolume-expander,-cloud-node-lifecycle,-ttl'
+{{- end }}
 
 storage:
   persistence: true
   size: "{{ .Disk }}Gi"
+{{- end }}
 sync:
   ingresses:
     enabled: true
   serviceaccounts:
     enabled: true
+  # Pausing evicts synced pods through the disruption budgets of the vcluster
+  poddisruptionbudgets:
+    enabled: true
 syncer:
   extraArgs:
     - --tls-san="{{ .ProjectSlug }}.{{ .ProjectSlug }}"
@@ -89,20 +177,24 @@ ingress:
 
 init:
   manifests: |
+    {{- range $binding := .RoleBindings }}
     ---
     apiVersion: rbac.authorization.k8s.io/v1
     kind: ClusterRoleBinding
     metadata:
-      name: admins
+      name: "{{ $binding.Name }}"
+      labels:
+        app.kubernetes.io/managed-by: launchboxhq
     subjects:
-    {{- range $user := .Users }}
-    - kind: User
-      name: {{ $user.Email }}
-      apiGroup: rbac.authorization.k8s.io
+    {{- range $subject := $binding.Subjects }}
+    - kind: {{ $subject.Kind }}
+      name: "{{ $subject.Name }}"
+      apiGroup: {{ $subject.APIGroup }}
     {{- end }}
     roleRef:
       kind: ClusterRole
-      name: cluster-admin
+      name: "{{ $binding.ClusterRole }}"
       apiGroup: rbac.authorization.k8s.io
+    {{- end }}
 
 `))
@@ -0,0 +1,119 @@
+package project
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/launchboxio/operator/api/v1alpha1"
+	"github.com/launchboxio/operator/internal/versions"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRoleBindingsForUsers(t *testing.T) {
+	bindings := roleBindingsForUsers([]v1alpha1.ProjectUser{
+		{Email: "owner@launchboxhq.io", ClusterRole: "cluster-admin"},
+		{Email: "contractor@example.com", ClusterRole: "view"},
+		{Group: "developers", ClusterRole: "edit"},
+		{Email: "admin@launchboxhq.io", ClusterRole: "cluster-admin"},
+	})
+
+	if len(bindings) != 3 {
+		t.Fatalf("expected 3 bindings, got %d", len(bindings))
+	}
+	for i, role := range []string{"cluster-admin", "edit", "view"} {
+		if bindings[i].ClusterRole != role {
+			t.Fatalf("expected binding %d to be for %s, got %s", i, role, bindings[i].ClusterRole)
+		}
+	}
+	if len(bindings[0].Subjects) != 2 {
+		t.Fatalf("expected 2 cluster-admin subjects, got %d", len(bindings[0].Subjects))
+	}
+	if bindings[1].Subjects[0].Kind != rbacv1.GroupKind {
+		t.Fatalf("expected a group subject, got %s", bindings[1].Subjects[0].Kind)
+	}
+}
+
+func TestValuesTemplateRendersRoleBindings(t *testing.T) {
+	var values bytes.Buffer
+	err := ValuesTemplate.Execute(&values, ValuesTemplateArgs{
+		ProjectSlug: "testing",
+		RoleBindings: roleBindingsForUsers([]v1alpha1.ProjectUser{
+			{Email: "owner@launchboxhq.io", ClusterRole: "cluster-admin"},
+			{Group: "contractors", ClusterRole: "view"},
+		}),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rendered := struct {
+		Init struct {
+			Manifests string `json:"manifests"`
+		} `json:"init"`
+	}{}
+	if err := yaml.Unmarshal(values.Bytes(), &rendered); err != nil {
+		t.Fatalf("failed parsing values: %v", err)
+	}
+
+	var roles []string
+	for _, manifest := range strings.Split(rendered.Init.Manifests, "---") {
+		if strings.TrimSpace(manifest) == "" {
+			continue
+		}
+		binding := &rbacv1.ClusterRoleBinding{}
+		if err := yaml.Unmarshal([]byte(manifest), binding); err != nil {
+			t.Fatalf("failed parsing manifest: %v", err)
+		}
+		roles = append(roles, binding.RoleRef.Name)
+	}
+	if strings.Join(roles, ",") != "cluster-admin,view" {
+		t.Fatalf("unexpected bindings rendered: %v", roles)
+	}
+}
+
+func TestValuesTemplateRendersDistros(t *testing.T) {
+	for _, distro := range []versions.Distro{versions.DistroK3s, versions.DistroK0s, versions.DistroK8s} {
+		release, err := versions.Default.Resolve(distro, "")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", distro, err)
+		}
+
+		var values bytes.Buffer
+		if err := ValuesTemplate.Execute(&values, ValuesTemplateArgs{
+			ProjectSlug: "testing",
+			Distro:      distro,
+			Release:     *release,
+		}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", distro, err)
+		}
+
+		rendered := map[string]interface{}{}
+		if err := yaml.Unmarshal(values.Bytes(), &rendered); err != nil {
+			t.Fatalf("%s: failed parsing values: %v", distro, err)
+		}
+		if _, ok := rendered["sync"]; !ok {
+			t.Fatalf("%s: expected sync values to be rendered", distro)
+		}
+	}
+}
+
+func TestValuesTemplateSyncsDisruptionBudgets(t *testing.T) {
+	var values bytes.Buffer
+	if err := ValuesTemplate.Execute(&values, ValuesTemplateArgs{ProjectSlug: "testing"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rendered := struct {
+		Sync map[string]struct {
+			Enabled bool `json:"enabled"`
+		} `json:"sync"`
+	}{}
+	if err := yaml.Unmarshal(values.Bytes(), &rendered); err != nil {
+		t.Fatalf("failed parsing values: %v", err)
+	}
+	if !rendered.Sync["poddisruptionbudgets"].Enabled {
+		t.Fatal("expected PodDisruptionBudgets to be synced, so pausing respects them")
+	}
+}
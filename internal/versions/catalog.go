@@ -0,0 +1,147 @@
+package versions
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Distro is the Kubernetes distribution backing a vcluster
+type Distro string
+
+const (
+	DistroK3s Distro = "k3s"
+	DistroK0s Distro = "k0s"
+	DistroK8s Distro = "k8s"
+)
+
+// CatalogKey is the key of the catalog in the version catalog ConfigMap
+const CatalogKey = "versions.yaml"
+
+// Release is a Kubernetes version available for a distro
+type Release struct {
+	Version string `json:"version"`
+
+	// Image is the k3s or k0s image for this version
+	Image string `json:"image,omitempty"`
+
+	// ApiServer, ControllerManager, Scheduler and Etcd are the
+	// images used by the vanilla k8s distro
+	ApiServer         string `json:"apiServer,omitempty"`
+	ControllerManager string `json:"controllerManager,omitempty"`
+	Scheduler         string `json:"scheduler,omitempty"`
+	Etcd              string `json:"etcd,omitempty"`
+}
+
+// Catalog lists the releases available for each distro
+type Catalog map[Distro][]Release
+
+// UnsupportedVersionError is returned when a catalog has
+// no release matching the requested version
+type UnsupportedVersionError struct {
+	Distro  Distro
+	Version string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("kubernetes version %q is not supported by distro %s", e.Version, e.Distro)
+}
+
+// Parse reads a catalog from its YAML representation
+func Parse(data []byte) (Catalog, error) {
+	catalog := Catalog{}
+	if err := yaml.Unmarshal(data, &catalog); err != nil {
+		return nil, err
+	}
+	for distro, releases := range catalog {
+		for _, release := range releases {
+			if _, err := semver.StrictNewVersion(release.Version); err != nil {
+				return nil, fmt.Errorf("invalid %s version %q: %w", distro, release.Version, err)
+			}
+		}
+	}
+	return catalog, nil
+}
+
+// Load reads the catalog from a ConfigMap, falling back
+// to the Default catalog if the ConfigMap doesn't exist
+func Load(ctx context.Context, c client.Reader, key types.NamespacedName) (Catalog, error) {
+	configMap := &v1.ConfigMap{}
+	if err := c.Get(ctx, key, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return Default, nil
+		}
+		return nil, err
+	}
+	return Parse([]byte(configMap.Data[CatalogKey]))
+}
+
+// Resolve finds the release for a version. An exact version such as
+// "1.27.3" must exist in the catalog, while a minor version such as
+// "1.27" resolves to its latest patch. An empty version resolves to
+// the latest release of the distro
+func (c Catalog) Resolve(distro Distro, version string) (*Release, error) {
+	if distro == "" {
+		distro = DistroK3s
+	}
+	releases := c[distro]
+
+	var constraint *semver.Constraints
+	var err error
+	switch strings.Count(version, ".") {
+	case 0:
+		if version != "" {
+			return nil, &UnsupportedVersionError{Distro: distro, Version: version}
+		}
+		constraint, err = semver.NewConstraint("*")
+	case 1:
+		constraint, err = semver.NewConstraint("~" + version)
+	default:
+		constraint, err = semver.NewConstraint("=" + version)
+	}
+	if err != nil {
+		return nil, &UnsupportedVersionError{Distro: distro, Version: version}
+	}
+
+	var resolved *Release
+	var resolvedVersion *semver.Version
+	for i, release := range releases {
+		v, err := semver.NewVersion(release.Version)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if resolvedVersion == nil || v.GreaterThan(resolvedVersion) {
+			resolved = &releases[i]
+			resolvedVersion = v
+		}
+	}
+	if resolved == nil {
+		return nil, &UnsupportedVersionError{Distro: distro, Version: version}
+	}
+	return resolved, nil
+}
+
+// Versions returns the sorted versions available for a distro
+func (c Catalog) Versions(distro Distro) []string {
+	var versions []*semver.Version
+	for _, release := range c[distro] {
+		if v, err := semver.NewVersion(release.Version); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(semver.Collection(versions))
+
+	result := make([]string, len(versions))
+	for i, v := range versions {
+		result[i] = v.Original()
+	}
+	return result
+}
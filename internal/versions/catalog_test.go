@@ -0,0 +1,71 @@
+package versions
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		distro   Distro
+		version  string
+		expected string
+	}{
+		{DistroK3s, "1.27.3", "rancher/k3s:v1.27.3-k3s1"},
+		{DistroK3s, "1.26.7", "rancher/k3s:v1.26.7-k3s1"},
+		{DistroK3s, "1.27", "rancher/k3s:v1.27.7-k3s2"},
+		{DistroK3s, "", "rancher/k3s:v1.28.3-k3s2"},
+		{"", "1.28", "rancher/k3s:v1.28.3-k3s2"},
+		{DistroK0s, "1.27", "k0sproject/k0s:v1.27.6-k0s.0"},
+	} {
+		release, err := Default.Resolve(tc.distro, tc.version)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", tc.distro, tc.version, err)
+		}
+		if release.Image != tc.expected {
+			t.Fatalf("%s %s: expected %q, got %q", tc.distro, tc.version, tc.expected, release.Image)
+		}
+	}
+}
+
+func TestResolveVanilla(t *testing.T) {
+	release, err := Default.Resolve(DistroK8s, "1.26")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release.ApiServer != "registry.k8s.io/kube-apiserver:v1.26.10" {
+		t.Fatalf("unexpected api server image %q", release.ApiServer)
+	}
+}
+
+func TestResolveUnsupportedVersion(t *testing.T) {
+	for _, version := range []string{"1.25.15", "1.25", "1.29.0", "latest"} {
+		_, err := Default.Resolve(DistroK3s, version)
+		var unsupported *UnsupportedVersionError
+		if !errors.As(err, &unsupported) {
+			t.Fatalf("%s: expected unsupported version error, got %v", version, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	catalog, err := Parse([]byte(`
+k3s:
+  - version: 1.28.3
+    image: registry.internal/k3s:v1.28.3-k3s2
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release, err := catalog.Resolve(DistroK3s, "1.28")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release.Image != "registry.internal/k3s:v1.28.3-k3s2" {
+		t.Fatalf("unexpected image %q", release.Image)
+	}
+
+	if _, err := Parse([]byte("k3s:\n  - version: latest\n")); err == nil {
+		t.Fatal("expected invalid version to be rejected")
+	}
+}
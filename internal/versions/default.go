@@ -0,0 +1,56 @@
+package versions
+
+// Default is used when no version catalog ConfigMap exists
+var Default = Catalog{
+	DistroK3s: {
+		{Version: "1.28.3", Image: "rancher/k3s:v1.28.3-k3s2"},
+		{Version: "1.28.2", Image: "rancher/k3s:v1.28.2-k3s1"},
+		{Version: "1.28.1", Image: "rancher/k3s:v1.28.1-k3s1"},
+		{Version: "1.27.7", Image: "rancher/k3s:v1.27.7-k3s2"},
+		{Version: "1.27.6", Image: "rancher/k3s:v1.27.6-k3s1"},
+		{Version: "1.27.5", Image: "rancher/k3s:v1.27.5-k3s1"},
+		{Version: "1.27.4", Image: "rancher/k3s:v1.27.4-k3s1"},
+		{Version: "1.27.3", Image: "rancher/k3s:v1.27.3-k3s1"},
+		{Version: "1.27.2", Image: "rancher/k3s:v1.27.2-k3s1"},
+		{Version: "1.27.1", Image: "rancher/k3s:v1.27.1-k3s1"},
+		{Version: "1.26.10", Image: "rancher/k3s:v1.26.10-k3s2"},
+		{Version: "1.26.9", Image: "rancher/k3s:v1.26.9-k3s1"},
+		{Version: "1.26.8", Image: "rancher/k3s:v1.26.8-k3s1"},
+		{Version: "1.26.7", Image: "rancher/k3s:v1.26.7-k3s1"},
+		{Version: "1.26.6", Image: "rancher/k3s:v1.26.6-k3s1"},
+		{Version: "1.26.5", Image: "rancher/k3s:v1.26.5-k3s1"},
+		{Version: "1.26.4", Image: "rancher/k3s:v1.26.4-k3s1"},
+		{Version: "1.26.3", Image: "rancher/k3s:v1.26.3-k3s1"},
+		{Version: "1.26.2", Image: "rancher/k3s:v1.26.2-k3s1"},
+		{Version: "1.26.1", Image: "rancher/k3s:v1.26.1-k3s1"},
+		{Version: "1.26.0", Image: "rancher/k3s:v1.26.0-k3s2"},
+	},
+	DistroK0s: {
+		{Version: "1.28.2", Image: "k0sproject/k0s:v1.28.2-k0s.0"},
+		{Version: "1.27.6", Image: "k0sproject/k0s:v1.27.6-k0s.0"},
+		{Version: "1.26.9", Image: "k0sproject/k0s:v1.26.9-k0s.0"},
+	},
+	DistroK8s: {
+		{
+			Version:           "1.28.3",
+			ApiServer:         "registry.k8s.io/kube-apiserver:v1.28.3",
+			ControllerManager: "registry.k8s.io/kube-controller-manager:v1.28.3",
+			Scheduler:         "registry.k8s.io/kube-scheduler:v1.28.3",
+			Etcd:              "registry.k8s.io/etcd:3.5.9-0",
+		},
+		{
+			Version:           "1.27.7",
+			ApiServer:         "registry.k8s.io/kube-apiserver:v1.27.7",
+			ControllerManager: "registry.k8s.io/kube-controller-manager:v1.27.7",
+			Scheduler:         "registry.k8s.io/kube-scheduler:v1.27.7",
+			Etcd:              "registry.k8s.io/etcd:3.5.7-0",
+		},
+		{
+			Version:           "1.26.10",
+			ApiServer:         "registry.k8s.io/kube-apiserver:v1.26.10",
+			ControllerManager: "registry.k8s.io/kube-controller-manager:v1.26.10",
+			Scheduler:         "registry.k8s.io/kube-scheduler:v1.26.10",
+			Etcd:              "registry.k8s.io/etcd:3.5.6-0",
+		},
+	},
+}
@@ -0,0 +1,119 @@
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// activationPolicies are the revision activation policies of an addon
+var activationPolicies = []string{
+	corev1alpha1.AddonActivationAutomatic,
+	corev1alpha1.AddonActivationManual,
+}
+
+// SetupAddonWebhookWithManager registers the Addon webhooks
+func SetupAddonWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1alpha1.Addon{}).
+		WithDefaulter(&AddonCustomDefaulter{}).
+		WithValidator(&AddonCustomValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-core-launchboxhq-io-v1alpha1-addon,mutating=true,failurePolicy=fail,sideEffects=None,groups=core.launchboxhq.io,resources=addons,verbs=create;update,versions=v1alpha1,name=maddon.kb.io,admissionReviewVersions=v1
+
+// AddonCustomDefaulter sets the policies the addon
+// Configuration is created with when they're omitted
+type AddonCustomDefaulter struct{}
+
+var _ admission.CustomDefaulter = &AddonCustomDefaulter{}
+
+func (d *AddonCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	addon, ok := obj.(*corev1alpha1.Addon)
+	if !ok {
+		return fmt.Errorf("expected an Addon but got %T", obj)
+	}
+
+	if addon.Spec.PullPolicy == "" {
+		addon.Spec.PullPolicy = string(v1.PullAlways)
+	}
+	if addon.Spec.ActivationPolicy == "" {
+		addon.Spec.ActivationPolicy = corev1alpha1.AddonActivationAutomatic
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-core-launchboxhq-io-v1alpha1-addon,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.launchboxhq.io,resources=addons,verbs=create;update,versions=v1alpha1,name=vaddon.kb.io,admissionReviewVersions=v1
+
+// AddonCustomValidator refuses addons whose package
+// or policies can't be turned into a Configuration
+type AddonCustomValidator struct{}
+
+var _ admission.CustomValidator = &AddonCustomValidator{}
+
+func (v *AddonCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	addon, ok := obj.(*corev1alpha1.Addon)
+	if !ok {
+		return nil, fmt.Errorf("expected an Addon but got %T", obj)
+	}
+	return nil, v.validate(addon)
+}
+
+func (v *AddonCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	addon, ok := newObj.(*corev1alpha1.Addon)
+	if !ok {
+		return nil, fmt.Errorf("expected an Addon but got %T", newObj)
+	}
+	return nil, v.validate(addon)
+}
+
+func (v *AddonCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *AddonCustomValidator) validate(addon *corev1alpha1.Addon) error {
+	spec := field.NewPath("spec")
+	var allErrs field.ErrorList
+
+	if addon.Spec.OciRegistry == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("ociRegistry"), ""))
+	}
+	if addon.Spec.OciVersion == "" && addon.Spec.Channel == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("ociVersion"), "either ociVersion or channel must be set"))
+	}
+	if addon.Spec.Channel != "" {
+		if _, err := semver.NewConstraint(addon.Spec.Channel); err != nil {
+			allErrs = append(allErrs, field.Invalid(spec.Child("channel"), addon.Spec.Channel, err.Error()))
+		}
+	}
+	if addon.Spec.PullPolicy != "" && !contains(pullPolicies, addon.Spec.PullPolicy) {
+		allErrs = append(allErrs, field.NotSupported(spec.Child("pullPolicy"), addon.Spec.PullPolicy, pullPolicies))
+	}
+	if addon.Spec.ActivationPolicy != "" && !contains(activationPolicies, addon.Spec.ActivationPolicy) {
+		allErrs = append(allErrs, field.NotSupported(spec.Child("activationPolicy"), addon.Spec.ActivationPolicy, activationPolicies))
+	}
+	if addon.Spec.ActiveRevision != "" && addon.Spec.ActivationPolicy != corev1alpha1.AddonActivationManual {
+		allErrs = append(allErrs, field.Forbidden(spec.Child("activeRevision"), "requires the Manual activation policy"))
+	}
+	if limit := addon.Spec.RevisionHistoryLimit; limit != nil && *limit < 0 {
+		allErrs = append(allErrs, field.Invalid(spec.Child("revisionHistoryLimit"), *limit, "must be greater than or equal to 0"))
+	}
+	for i, secret := range addon.Spec.PackagePullSecrets {
+		if secret.Name == "" {
+			allErrs = append(allErrs, field.Required(spec.Child("packagePullSecrets").Index(i).Child("name"), ""))
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(corev1alpha1.GroupVersion.WithKind("Addon").GroupKind(), addon.Name, allErrs)
+}
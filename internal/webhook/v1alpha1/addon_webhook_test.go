@@ -0,0 +1,75 @@
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testAddon() *corev1alpha1.Addon {
+	return &corev1alpha1.Addon{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres"},
+		Spec: corev1alpha1.AddonSpec{
+			Name:        "postgres",
+			OciRegistry: "ghcr.io/launchboxio/addons/postgres",
+			OciVersion:  "v1.0.0",
+		},
+	}
+}
+
+func TestAddonDefault(t *testing.T) {
+	addon := testAddon()
+	if err := (&AddonCustomDefaulter{}).Default(context.TODO(), addon); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addon.Spec.PullPolicy != "Always" || addon.Spec.ActivationPolicy != "Automatic" {
+		t.Fatalf("unexpected policies %q and %q", addon.Spec.PullPolicy, addon.Spec.ActivationPolicy)
+	}
+}
+
+func TestAddonValidateCreate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(*corev1alpha1.Addon)
+		field  string
+	}{
+		{"valid", func(a *corev1alpha1.Addon) { a.Spec.PullPolicy = "IfNotPresent" }, ""},
+		{"unknown pull policy", func(a *corev1alpha1.Addon) { a.Spec.PullPolicy = "ifnotpresent" }, "spec.pullPolicy"},
+		{"unknown activation policy", func(a *corev1alpha1.Addon) { a.Spec.ActivationPolicy = "Later" }, "spec.activationPolicy"},
+		{"missing registry", func(a *corev1alpha1.Addon) { a.Spec.OciRegistry = "" }, "spec.ociRegistry"},
+		{"unnamed pull secret", func(a *corev1alpha1.Addon) {
+			a.Spec.PackagePullSecrets = []v1.LocalObjectReference{{}}
+		}, "spec.packagePullSecrets[0].name"},
+		{"channel", func(a *corev1alpha1.Addon) { a.Spec.OciVersion, a.Spec.Channel = "", "~1.4" }, ""},
+		{"missing version", func(a *corev1alpha1.Addon) { a.Spec.OciVersion = "" }, "spec.ociVersion"},
+		{"invalid channel", func(a *corev1alpha1.Addon) { a.Spec.Channel = "one point four" }, "spec.channel"},
+		{"manual active revision", func(a *corev1alpha1.Addon) {
+			a.Spec.ActivationPolicy = "Manual"
+			a.Spec.ActiveRevision = "postgres-0123456789ab"
+		}, ""},
+		{"automatic active revision", func(a *corev1alpha1.Addon) {
+			a.Spec.ActivationPolicy = "Automatic"
+			a.Spec.ActiveRevision = "postgres-0123456789ab"
+		}, "spec.activeRevision"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addon := testAddon()
+			tc.mutate(addon)
+
+			_, err := (&AddonCustomValidator{}).ValidateCreate(context.TODO(), addon)
+			if tc.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.field) {
+				t.Fatalf("expected an error on %s, got %v", tc.field, err)
+			}
+		})
+	}
+}
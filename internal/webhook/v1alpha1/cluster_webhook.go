@@ -0,0 +1,140 @@
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// pullPolicies are the image pull policies accepted by
+// the agent and addon packages
+var pullPolicies = []string{
+	string(v1.PullAlways),
+	string(v1.PullIfNotPresent),
+	string(v1.PullNever),
+}
+
+// SetupClusterWebhookWithManager registers the Cluster webhooks
+func SetupClusterWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1alpha1.Cluster{}).
+		WithDefaulter(&ClusterCustomDefaulter{}).
+		WithValidator(&ClusterCustomValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-core-launchboxhq-io-v1alpha1-cluster,mutating=true,failurePolicy=fail,sideEffects=None,groups=core.launchboxhq.io,resources=clusters,verbs=create;update,versions=v1alpha1,name=mcluster.kb.io,admissionReviewVersions=v1
+
+// ClusterCustomDefaulter fills in the defaults the
+// operator would otherwise apply implicitly
+type ClusterCustomDefaulter struct{}
+
+var _ admission.CustomDefaulter = &ClusterCustomDefaulter{}
+
+func (d *ClusterCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	cluster, ok := obj.(*corev1alpha1.Cluster)
+	if !ok {
+		return fmt.Errorf("expected a Cluster but got %T", obj)
+	}
+
+	if cluster.Spec.Ingress.Namespace == "" {
+		cluster.Spec.Ingress.Namespace = "ingress-nginx"
+	}
+	if cluster.Spec.Vcluster.MaxConcurrentUpgrades == 0 {
+		cluster.Spec.Vcluster.MaxConcurrentUpgrades = 1
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-core-launchboxhq-io-v1alpha1-cluster,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.launchboxhq.io,resources=clusters,verbs=create;update,versions=v1alpha1,name=vcluster.kb.io,admissionReviewVersions=v1
+
+// ClusterCustomValidator refuses clusters that would only
+// fail once the operator reconciles them
+type ClusterCustomValidator struct{}
+
+var _ admission.CustomValidator = &ClusterCustomValidator{}
+
+func (v *ClusterCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	cluster, ok := obj.(*corev1alpha1.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("expected a Cluster but got %T", obj)
+	}
+	return nil, v.validate(cluster)
+}
+
+func (v *ClusterCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	cluster, ok := newObj.(*corev1alpha1.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("expected a Cluster but got %T", newObj)
+	}
+	return nil, v.validate(cluster)
+}
+
+func (v *ClusterCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *ClusterCustomValidator) validate(cluster *corev1alpha1.Cluster) error {
+	spec := field.NewPath("spec")
+	var allErrs field.ErrorList
+
+	agent := cluster.Spec.Agent
+	if agent.Enabled && (cluster.Spec.CredentialsRef == nil || cluster.Spec.CredentialsRef.Name == "") {
+		allErrs = append(allErrs, field.Required(spec.Child("credentialsRef", "name"), "the agent requires credentials"))
+	}
+	if agent.PullPolicy != "" && !contains(pullPolicies, string(agent.PullPolicy)) {
+		allErrs = append(allErrs, field.NotSupported(spec.Child("agent", "pullPolicy"), agent.PullPolicy, pullPolicies))
+	}
+
+	allErrs = append(allErrs, validateChartSource(cluster.Spec.Charts.Vcluster, spec.Child("charts", "vcluster"))...)
+	allErrs = append(allErrs, validateChartSource(cluster.Spec.Charts.Agent, spec.Child("charts", "agent"))...)
+	allErrs = append(allErrs, validateValuesSources(cluster.Spec.VclusterValues, spec.Child("vclusterValues"))...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(corev1alpha1.GroupVersion.WithKind("Cluster").GroupKind(), cluster.Name, allErrs)
+}
+
+// validateChartSource requires exactly one kind of source when set
+func validateChartSource(source *corev1alpha1.ChartSource, path *field.Path) field.ErrorList {
+	if source == nil {
+		return nil
+	}
+
+	sources := 0
+	for _, set := range []bool{source.Repository != nil, source.OCI != nil, source.Tarball != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return field.ErrorList{field.Invalid(path, "", "exactly one of repository, oci or tarball must be set")}
+	}
+
+	var allErrs field.ErrorList
+	switch {
+	case source.Repository != nil && source.Repository.URL == "":
+		allErrs = append(allErrs, field.Required(path.Child("repository", "url"), ""))
+	case source.OCI != nil && source.OCI.URL == "":
+		allErrs = append(allErrs, field.Required(path.Child("oci", "url"), ""))
+	case source.Tarball != nil && (source.Tarball.ConfigMapRef == nil) == (source.Tarball.Path == ""):
+		allErrs = append(allErrs, field.Invalid(path.Child("tarball"), "", "exactly one of configMapRef or path must be set"))
+	}
+	return allErrs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
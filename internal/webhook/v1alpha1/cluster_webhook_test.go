@@ -0,0 +1,51 @@
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClusterValidateCreate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(*corev1alpha1.Cluster)
+		field  string
+	}{
+		{"valid", func(c *corev1alpha1.Cluster) {}, ""},
+		{"missing credentials", func(c *corev1alpha1.Cluster) { c.Spec.CredentialsRef = nil }, "spec.credentialsRef.name"},
+		{"unknown pull policy", func(c *corev1alpha1.Cluster) { c.Spec.Agent.PullPolicy = "Sometimes" }, "spec.agent.pullPolicy"},
+		{"ambiguous chart source", func(c *corev1alpha1.Cluster) {
+			c.Spec.Charts.Agent = &corev1alpha1.ChartSource{
+				Repository: &corev1alpha1.HelmRepositorySource{URL: "https://charts.example.com"},
+				OCI:        &corev1alpha1.OCIRegistrySource{URL: "oci://registry.example.com/charts"},
+			}
+		}, "spec.charts.agent"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := &corev1alpha1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "lbx-system"},
+				Spec: corev1alpha1.ClusterSpec{
+					CredentialsRef: &v1.SecretReference{Name: "credentials"},
+					Agent:          corev1alpha1.ClusterAgentSpec{Enabled: true},
+				},
+			}
+			tc.mutate(cluster)
+
+			_, err := (&ClusterCustomValidator{}).ValidateCreate(context.TODO(), cluster)
+			if tc.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.field) {
+				t.Fatalf("expected an error on %s, got %v", tc.field, err)
+			}
+		})
+	}
+}
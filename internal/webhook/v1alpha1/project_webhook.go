@@ -0,0 +1,292 @@
+package v1alpha1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	projectscope "github.com/launchboxio/operator/internal/scope/project"
+	"github.com/launchboxio/operator/internal/versions"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// SetupProjectWebhookWithManager registers the Project webhooks. Kubernetes
+// versions are validated against the catalog read from versionCatalog
+func SetupProjectWebhookWithManager(mgr ctrl.Manager, versionCatalog types.NamespacedName) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1alpha1.Project{}).
+		WithDefaulter(&ProjectCustomDefaulter{}).
+		WithValidator(&ProjectCustomValidator{
+			Client:         mgr.GetClient(),
+			VersionCatalog: versionCatalog,
+		}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-core-launchboxhq-io-v1alpha1-project,mutating=true,failurePolicy=fail,sideEffects=None,groups=core.launchboxhq.io,resources=projects,verbs=create;update,versions=v1alpha1,name=mproject.kb.io,admissionReviewVersions=v1
+
+// ProjectCustomDefaulter fills in the defaults the
+// operator would otherwise apply implicitly
+type ProjectCustomDefaulter struct{}
+
+var _ admission.CustomDefaulter = &ProjectCustomDefaulter{}
+
+func (d *ProjectCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	project, ok := obj.(*corev1alpha1.Project)
+	if !ok {
+		return fmt.Errorf("expected a Project but got %T", obj)
+	}
+
+	if project.Spec.Distro == "" {
+		project.Spec.Distro = string(versions.DistroK3s)
+	}
+	if ref := project.Spec.ClusterRef; ref != nil && ref.Name != "" && ref.Namespace == "" {
+		ref.Namespace = project.Namespace
+	}
+	if hibernation := project.Spec.Hibernation; hibernation != nil {
+		for i := range hibernation.Schedules {
+			if hibernation.Schedules[i].TimeZone == "" {
+				hibernation.Schedules[i].TimeZone = "UTC"
+			}
+		}
+	}
+	for i := range project.Spec.Addons {
+		if project.Spec.Addons[i].InstallationName == "" {
+			project.Spec.Addons[i].InstallationName = project.Spec.Addons[i].AddonName
+		}
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-core-launchboxhq-io-v1alpha1-project,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.launchboxhq.io,resources=projects,verbs=create;update,versions=v1alpha1,name=vproject.kb.io,admissionReviewVersions=v1
+
+// ProjectCustomValidator refuses projects that would only
+// fail once the operator reconciles them
+type ProjectCustomValidator struct {
+	Client client.Reader
+
+	// VersionCatalog is the ConfigMap listing the supported Kubernetes versions
+	VersionCatalog types.NamespacedName
+}
+
+var _ admission.CustomValidator = &ProjectCustomValidator{}
+
+func (v *ProjectCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	project, ok := obj.(*corev1alpha1.Project)
+	if !ok {
+		return nil, fmt.Errorf("expected a Project but got %T", obj)
+	}
+	return nil, v.validate(ctx, project, nil)
+}
+
+func (v *ProjectCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	project, ok := newObj.(*corev1alpha1.Project)
+	if !ok {
+		return nil, fmt.Errorf("expected a Project but got %T", newObj)
+	}
+	old, ok := oldObj.(*corev1alpha1.Project)
+	if !ok {
+		return nil, fmt.Errorf("expected a Project but got %T", oldObj)
+	}
+	return nil, v.validate(ctx, project, old)
+}
+
+func (v *ProjectCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks a created project, or an updated project
+// against its previous version when old is set
+func (v *ProjectCustomValidator) validate(ctx context.Context, project *corev1alpha1.Project, old *corev1alpha1.Project) error {
+	spec := field.NewPath("spec")
+	var allErrs field.ErrorList
+
+	if old != nil {
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(project.Spec.Slug, old.Spec.Slug, spec.Child("slug"))...)
+		// Changing the distro swaps the chart of the vcluster
+		// release, which loses the data of the vcluster
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(distroOrDefault(project), distroOrDefault(old), spec.Child("distro"))...)
+	}
+	for _, msg := range validation.IsDNS1123Label(project.Spec.Slug) {
+		allErrs = append(allErrs, field.Invalid(spec.Child("slug"), project.Spec.Slug, msg))
+	}
+
+	// Versions are only checked when they change, so that projects
+	// aren't frozen when a version is dropped from the catalog
+	if old == nil || old.Spec.KubernetesVersion != project.Spec.KubernetesVersion || old.Spec.Distro != project.Spec.Distro {
+		versionErrs, err := v.validateKubernetesVersion(ctx, project, spec)
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		allErrs = append(allErrs, versionErrs...)
+	}
+
+	allErrs = append(allErrs, validateResources(project.Spec.Resources, spec.Child("resources"))...)
+	allErrs = append(allErrs, validateUsers(project.Spec.Users, spec.Child("users"))...)
+	allErrs = append(allErrs, validateAddons(project.Spec.Addons, spec.Child("addons"))...)
+	allErrs = append(allErrs, validateValuesSources(project.Spec.ValuesOverrides, spec.Child("valuesOverrides"))...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(corev1alpha1.GroupVersion.WithKind("Project").GroupKind(), project.Name, allErrs)
+}
+
+func (v *ProjectCustomValidator) validateKubernetesVersion(ctx context.Context, project *corev1alpha1.Project, spec *field.Path) (field.ErrorList, error) {
+	catalog, err := versions.Load(ctx, v.Client, v.VersionCatalog)
+	if err != nil {
+		return nil, err
+	}
+
+	distro := distroOrDefault(project)
+	_, err = catalog.Resolve(distro, project.Spec.KubernetesVersion)
+	var unsupported *versions.UnsupportedVersionError
+	if errors.As(err, &unsupported) {
+		return field.ErrorList{
+			field.NotSupported(spec.Child("kubernetesVersion"), project.Spec.KubernetesVersion, catalog.Versions(distro)),
+		}, nil
+	}
+	return nil, err
+}
+
+// distroOrDefault returns the distro of a project, which
+// defaults to k3s for projects created before the webhook
+func distroOrDefault(project *corev1alpha1.Project) versions.Distro {
+	if project.Spec.Distro == "" {
+		return versions.DistroK3s
+	}
+	return versions.Distro(project.Spec.Distro)
+}
+
+// validateResources refuses negative sizes and quotas, and
+// default requests exceeding the default limits
+func validateResources(resources corev1alpha1.Resources, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, apivalidation.ValidateNonnegativeField(int64(resources.Cpu), path.Child("cpu"))...)
+	allErrs = append(allErrs, apivalidation.ValidateNonnegativeField(int64(resources.Memory), path.Child("memory"))...)
+	allErrs = append(allErrs, apivalidation.ValidateNonnegativeField(int64(resources.Disk), path.Child("disk"))...)
+
+	for name, list := range map[string]v1.ResourceList{
+		"requests":        resources.Requests,
+		"limits":          resources.Limits,
+		"defaultRequests": resources.DefaultRequests,
+		"defaultLimits":   resources.DefaultLimits,
+	} {
+		for resourceName, quantity := range list {
+			allErrs = append(allErrs, validateNonnegativeQuantity(quantity, path.Child(name).Key(string(resourceName)))...)
+		}
+	}
+
+	for resourceName, request := range resources.DefaultRequests {
+		limit, ok := resources.DefaultLimits[resourceName]
+		if ok && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("defaultRequests").Key(string(resourceName)), request.String(),
+				fmt.Sprintf("must be less than or equal to the default limit of %s", limit.String())))
+		}
+	}
+
+	for resourceName, count := range resources.Objects {
+		allErrs = append(allErrs, apivalidation.ValidateNonnegativeField(count, path.Child("objects").Key(resourceName))...)
+	}
+
+	storageClasses := map[string]bool{}
+	for i, storageClass := range resources.StorageClasses {
+		classPath := path.Child("storageClasses").Index(i)
+		switch {
+		case storageClass.Name == "":
+			allErrs = append(allErrs, field.Required(classPath.Child("name"), ""))
+		case storageClasses[storageClass.Name]:
+			allErrs = append(allErrs, field.Duplicate(classPath.Child("name"), storageClass.Name))
+		}
+		storageClasses[storageClass.Name] = true
+
+		if storageClass.Storage != nil {
+			allErrs = append(allErrs, validateNonnegativeQuantity(*storageClass.Storage, classPath.Child("storage"))...)
+		}
+		if storageClass.PersistentVolumeClaims != nil {
+			allErrs = append(allErrs, apivalidation.ValidateNonnegativeField(*storageClass.PersistentVolumeClaims, classPath.Child("persistentVolumeClaims"))...)
+		}
+	}
+	return allErrs
+}
+
+func validateNonnegativeQuantity(quantity resource.Quantity, path *field.Path) field.ErrorList {
+	if quantity.Sign() < 0 {
+		return field.ErrorList{field.Invalid(path, quantity.String(), "must be greater than or equal to 0")}
+	}
+	return nil
+}
+
+// validateUsers requires each project user to be either a user or a
+// group, since a user is bound as a single RoleBinding subject
+func validateUsers(users []corev1alpha1.ProjectUser, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, user := range users {
+		switch {
+		case user.Email != "" && user.Group != "":
+			allErrs = append(allErrs, field.Forbidden(path.Index(i).Child("group"), "may not be set together with email"))
+		case user.Email == "" && user.Group == "":
+			allErrs = append(allErrs, field.Required(path.Index(i), "one of email or group is required"))
+		}
+	}
+	return allErrs
+}
+
+// validateAddons requires the installation names of a project's addons,
+// which name their claims, to be unique
+func validateAddons(addons []corev1alpha1.ProjectAddonSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	for i, addon := range addons {
+		if addon.AddonName == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("addonName"), ""))
+			continue
+		}
+		name := addon.InstallationName
+		if name == "" {
+			name = addon.AddonName
+		}
+		if names[name] {
+			allErrs = append(allErrs, field.Duplicate(path.Index(i).Child("installationName"), name))
+		}
+		names[name] = true
+	}
+	return allErrs
+}
+
+// validateValuesSources requires exactly one source for each set of
+// values, and refuses inline values overriding operator managed values
+func validateValuesSources(sources []corev1alpha1.ValuesSource, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, source := range sources {
+		sourcePath := path.Index(i)
+		if (source.Values == "") == (source.ConfigMapKeyRef == nil) {
+			allErrs = append(allErrs, field.Invalid(sourcePath, "", "exactly one of values or configMapKeyRef must be set"))
+			continue
+		}
+		if source.Values == "" {
+			continue
+		}
+
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(source.Values), &values); err != nil {
+			allErrs = append(allErrs, field.Invalid(sourcePath.Child("values"), "", err.Error()))
+			continue
+		}
+		if err := projectscope.ValidateOverrides(values); err != nil {
+			allErrs = append(allErrs, field.Forbidden(sourcePath.Child("values"), err.Error()))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,145 @@
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newProjectValidator() *ProjectCustomValidator {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	return &ProjectCustomValidator{
+		Client:         fakeclient.NewClientBuilder().WithScheme(scheme).Build(),
+		VersionCatalog: types.NamespacedName{Name: "kubernetes-versions", Namespace: "lbx-system"},
+	}
+}
+
+func testProject() *corev1alpha1.Project {
+	return &corev1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "team-a"},
+		Spec: corev1alpha1.ProjectSpec{
+			Slug:              "staging-team-a",
+			KubernetesVersion: "1.27",
+			Distro:            "k3s",
+		},
+	}
+}
+
+func TestProjectDefault(t *testing.T) {
+	project := testProject()
+	project.Spec.Distro = ""
+	project.Spec.ClusterRef = &corev1alpha1.ClusterReference{Name: "default"}
+	project.Spec.Addons = []corev1alpha1.ProjectAddonSpec{{AddonName: "postgres"}}
+
+	if err := (&ProjectCustomDefaulter{}).Default(context.TODO(), project); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if project.Spec.Distro != "k3s" {
+		t.Fatalf("expected the k3s distro, got %q", project.Spec.Distro)
+	}
+	if project.Spec.ClusterRef.Namespace != "team-a" {
+		t.Fatalf("expected the cluster namespace to default to the project namespace")
+	}
+	if project.Spec.Addons[0].InstallationName != "postgres" {
+		t.Fatalf("expected the installation name to default to the addon name")
+	}
+}
+
+func TestProjectValidateCreate(t *testing.T) {
+	negative := resource.MustParse("-1")
+	for _, tc := range []struct {
+		name   string
+		mutate func(*corev1alpha1.Project)
+		field  string
+	}{
+		{"valid", func(p *corev1alpha1.Project) {}, ""},
+		{"invalid slug", func(p *corev1alpha1.Project) { p.Spec.Slug = "Staging_A" }, "spec.slug"},
+		{"unknown version", func(p *corev1alpha1.Project) { p.Spec.KubernetesVersion = "1.12" }, "spec.kubernetesVersion"},
+		{"negative cpu", func(p *corev1alpha1.Project) { p.Spec.Resources.Cpu = -1 }, "spec.resources.cpu"},
+		{"negative quota", func(p *corev1alpha1.Project) {
+			p.Spec.Resources.Requests = v1.ResourceList{v1.ResourceMemory: negative}
+		}, "spec.resources.requests[memory]"},
+		{"default request above limit", func(p *corev1alpha1.Project) {
+			p.Spec.Resources.DefaultRequests = v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}
+			p.Spec.Resources.DefaultLimits = v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")}
+		}, "spec.resources.defaultRequests[cpu]"},
+		{"user and group", func(p *corev1alpha1.Project) {
+			p.Spec.Users = []corev1alpha1.ProjectUser{
+				{Email: "jane@launchboxhq.io", ClusterRole: "admin"},
+				{Email: "john@launchboxhq.io", Group: "developers", ClusterRole: "edit"},
+			}
+		}, "spec.users[1].group"},
+		{"user without subject", func(p *corev1alpha1.Project) {
+			p.Spec.Users = []corev1alpha1.ProjectUser{{ClusterRole: "view"}}
+		}, "spec.users[0]"},
+		{"duplicate installation names", func(p *corev1alpha1.Project) {
+			p.Spec.Addons = []corev1alpha1.ProjectAddonSpec{
+				{AddonName: "postgres", InstallationName: "database"},
+				{AddonName: "mysql", InstallationName: "database"},
+			}
+		}, "spec.addons[1].installationName"},
+		{"owned values override", func(p *corev1alpha1.Project) {
+			p.Spec.ValuesOverrides = []corev1alpha1.ValuesSource{{Values: "ingress:\n  host: example.com\n"}}
+		}, "spec.valuesOverrides[0].values"},
+		{"owned syncer flag override", func(p *corev1alpha1.Project) {
+			p.Spec.ValuesOverrides = []corev1alpha1.ValuesSource{{Values: "syncer:\n  extraArgs: [--tls-san=evil.example.com]\n"}}
+		}, "spec.valuesOverrides[0].values"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			project := testProject()
+			tc.mutate(project)
+
+			_, err := newProjectValidator().ValidateCreate(context.TODO(), project)
+			if tc.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.field) {
+				t.Fatalf("expected an error on %s, got %v", tc.field, err)
+			}
+		})
+	}
+}
+
+func TestProjectValidateUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(*corev1alpha1.Project)
+		field  string
+	}{
+		{"slug", func(p *corev1alpha1.Project) { p.Spec.Slug = "production-team-a" }, "spec.slug"},
+		{"distro", func(p *corev1alpha1.Project) { p.Spec.Distro = "k8s" }, "spec.distro"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			old := testProject()
+			project := testProject()
+			tc.mutate(project)
+
+			_, err := newProjectValidator().ValidateUpdate(context.TODO(), old, project)
+			if err == nil || !strings.Contains(err.Error(), tc.field) || !strings.Contains(err.Error(), "field is immutable") {
+				t.Fatalf("expected %s to be immutable, got %v", tc.field, err)
+			}
+		})
+	}
+
+	// Projects created before the webhook default to k3s
+	old := testProject()
+	old.Spec.Distro = ""
+	project := testProject()
+	project.Spec.Distro = "k3s"
+	if _, err := newProjectValidator().ValidateUpdate(context.TODO(), old, project); err != nil {
+		t.Fatalf("expected the defaulted distro to be accepted, got %v", err)
+	}
+}
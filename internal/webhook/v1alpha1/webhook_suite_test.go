@@ -0,0 +1,157 @@
+package v1alpha1
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// These tests run the webhooks behind a real API server, and are
+// skipped unless the envtest binaries are installed (see make test)
+
+var k8sClient client.Client
+var testEnv *envtest.Environment
+var cancel context.CancelFunc
+
+func TestWebhooks(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Webhook Suite")
+}
+
+var _ = BeforeSuite(func() {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.TODO())
+
+	By("bootstrapping test environment")
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join("..", "..", "..", "config", "webhook")},
+		},
+	}
+
+	cfg, err := testEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(cfg).NotTo(BeNil())
+
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(corev1alpha1.AddToScheme(scheme)).To(Succeed())
+	Expect(admissionv1.AddToScheme(scheme)).To(Succeed())
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	Expect(err).NotTo(HaveOccurred())
+
+	webhookInstallOptions := &testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme: scheme,
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    webhookInstallOptions.LocalServingHost,
+			Port:    webhookInstallOptions.LocalServingPort,
+			CertDir: webhookInstallOptions.LocalServingCertDir,
+		}),
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	versionCatalog := types.NamespacedName{Name: "kubernetes-versions", Namespace: "default"}
+	Expect(SetupProjectWebhookWithManager(mgr, versionCatalog)).To(Succeed())
+	Expect(SetupClusterWebhookWithManager(mgr)).To(Succeed())
+	Expect(SetupAddonWebhookWithManager(mgr)).To(Succeed())
+
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
+	dialer := &net.Dialer{Timeout: time.Second}
+	addrPort := fmt.Sprintf("%s:%d", webhookInstallOptions.LocalServingHost, webhookInstallOptions.LocalServingPort)
+	Eventually(func() error {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addrPort, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}).Should(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if testEnv == nil {
+		return
+	}
+	cancel()
+	By("tearing down the test environment")
+	Expect(testEnv.Stop()).To(Succeed())
+})
+
+var _ = Describe("Project webhook", func() {
+	It("rejects invalid projects", func() {
+		project := testProject()
+		project.Namespace = "default"
+		project.Spec.Slug = "Not_A_Label"
+
+		err := k8sClient.Create(context.TODO(), project)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.slug"))
+	})
+
+	It("defaults and keeps the slug immutable", func() {
+		project := testProject()
+		project.Namespace = "default"
+		project.Spec.Distro = ""
+		Expect(k8sClient.Create(context.TODO(), project)).To(Succeed())
+		Expect(project.Spec.Distro).To(Equal("k3s"))
+
+		project.Spec.Slug = "renamed"
+		err := k8sClient.Update(context.TODO(), project)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Addon webhook", func() {
+	It("defaults the package policies", func() {
+		addon := testAddon()
+		Expect(k8sClient.Create(context.TODO(), addon)).To(Succeed())
+		Expect(addon.Spec.PullPolicy).To(Equal("Always"))
+		Expect(addon.Spec.ActivationPolicy).To(Equal("Automatic"))
+	})
+
+	It("rejects unknown policies", func() {
+		addon := testAddon()
+		addon.ObjectMeta = metav1.ObjectMeta{Name: "mysql"}
+		addon.Spec.ActivationPolicy = "Later"
+
+		err := k8sClient.Create(context.TODO(), addon)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+})
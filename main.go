@@ -2,19 +2,35 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	crossplanev1 "github.com/crossplane/crossplane/apis/pkg/v1"
 	corev1alpha1 "github.com/launchboxio/operator/api/v1alpha1"
 	"github.com/launchboxio/operator/controllers"
+	"github.com/launchboxio/operator/internal/helm"
+	"github.com/launchboxio/operator/internal/registry"
+	clusterscope "github.com/launchboxio/operator/internal/scope/cluster"
+	webhookv1alpha1 "github.com/launchboxio/operator/internal/webhook/v1alpha1"
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 var (
@@ -28,11 +44,20 @@ var (
 			var metricsAddr string
 			var enableLeaderElection bool
 			var probeAddr string
+			var defaultCluster string
+			var versionCatalog string
+			var crossplaneNamespace string
 			flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 			flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 			flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 				"Enable leader election for controller manager. "+
 					"Enabling this will ensure there is only one active controller manager.")
+			flag.StringVar(&defaultCluster, "default-cluster", "lbx-system/default",
+				"The namespace/name of the Cluster used by projects without a clusterRef.")
+			flag.StringVar(&versionCatalog, "version-catalog", "lbx-system/kubernetes-versions",
+				"The namespace/name of the ConfigMap listing supported Kubernetes versions.")
+			flag.StringVar(&crossplaneNamespace, "crossplane-namespace", "crossplane-system",
+				"The namespace Crossplane runs in, which addon package pull secrets are read from.")
 			opts := zap.Options{
 				Development: true,
 			}
@@ -41,13 +66,57 @@ var (
 
 			ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+			defaultClusterKey, err := parseNamespacedName(defaultCluster)
+			if err != nil {
+				setupLog.Error(err, "invalid default cluster", "cluster", defaultCluster)
+				os.Exit(1)
+			}
+			versionCatalogKey, err := parseNamespacedName(versionCatalog)
+			if err != nil {
+				setupLog.Error(err, "invalid version catalog", "catalog", versionCatalog)
+				os.Exit(1)
+			}
+
 			mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 				Scheme: scheme,
 				//MetricsBindAddress:     metricsAddr,
-				//Port:                   9443,
+				WebhookServer:          webhook.NewServer(webhook.Options{Port: 9443}),
 				HealthProbeBindAddress: probeAddr,
-				LeaderElection:         enableLeaderElection,
-				LeaderElectionID:       "de4bbe6f.launchboxhq.io",
+				// Only the agent Deployment and heartbeat Lease, the
+				// version catalog namespace, Helm release secrets and the
+				// API server endpoints are cached, instead of every
+				// Deployment, Lease, ConfigMap, Secret and Endpoints. Other
+				// ConfigMaps and Secrets are read uncached
+				Cache: cache.Options{
+					ByObject: map[client.Object]cache.ByObject{
+						&corev1.ConfigMap{}: {
+							Namespaces: map[string]cache.Config{versionCatalogKey.Namespace: {}},
+						},
+						&corev1.Secret{}: {
+							Label: labels.SelectorFromSet(labels.Set{"owner": "helm"}),
+						},
+						&appsv1.Deployment{}: {
+							Namespaces: map[string]cache.Config{clusterscope.AgentNamespace: {}},
+						},
+						&coordinationv1.Lease{}: {
+							Namespaces: map[string]cache.Config{clusterscope.AgentNamespace: {}},
+						},
+						&corev1.Endpoints{}: {
+							Namespaces: map[string]cache.Config{metav1.NamespaceDefault: {}},
+							Field:      fields.OneTermEqualSelector("metadata.name", "kubernetes"),
+						},
+					},
+				},
+				// Pods are only listed when draining paused projects,
+				// so they're read directly instead of caching them
+				// across the cluster
+				Client: client.Options{
+					Cache: &client.CacheOptions{
+						DisableFor: []client.Object{&corev1.Pod{}},
+					},
+				},
+				LeaderElection:   enableLeaderElection,
+				LeaderElectionID: "de4bbe6f.launchboxhq.io",
 				// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 				// when the Manager ends. This requires the binary to immediately end when the
 				// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -65,28 +134,58 @@ var (
 				os.Exit(1)
 			}
 
+			helmClientFactory := helm.NewClientFactory(mgr.GetConfig())
+
 			if err = (&controllers.ProjectReconciler{
-				Client: mgr.GetClient(),
-				Scheme: mgr.GetScheme(),
+				Client:            mgr.GetClient(),
+				Scheme:            mgr.GetScheme(),
+				DefaultCluster:    defaultClusterKey,
+				VersionCatalog:    versionCatalogKey,
+				HelmClientFactory: helmClientFactory,
+				APIReader:         mgr.GetAPIReader(),
 			}).SetupWithManager(mgr); err != nil {
 				setupLog.Error(err, "unable to create controller", "controller", "Project")
 				os.Exit(1)
 			}
 
 			if err = (&controllers.ClusterReconciler{
-				Client: mgr.GetClient(),
-				Scheme: mgr.GetScheme(),
+				Client:            mgr.GetClient(),
+				Scheme:            mgr.GetScheme(),
+				DefaultCluster:    defaultClusterKey,
+				Recorder:          mgr.GetEventRecorderFor("cluster-controller"),
+				HelmClientFactory: helmClientFactory,
+				APIReader:         mgr.GetAPIReader(),
 			}).SetupWithManager(mgr); err != nil {
 				setupLog.Error(err, "unable to create controller", "controller", "Cluster")
 				os.Exit(1)
 			}
 			if err = (&controllers.AddonReconciler{
-				Client: mgr.GetClient(),
-				Scheme: mgr.GetScheme(),
+				Client:    mgr.GetClient(),
+				Scheme:    mgr.GetScheme(),
+				TagLister: registry.NewTagLister(registry.Options{}),
+
+				APIReader:                  mgr.GetAPIReader(),
+				PackagePullSecretNamespace: crossplaneNamespace,
 			}).SetupWithManager(mgr); err != nil {
 				setupLog.Error(err, "unable to create controller", "controller", "Addon")
 				os.Exit(1)
 			}
+			// Webhooks need serving certificates, and are
+			// disabled when running the operator locally
+			if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+				if err = webhookv1alpha1.SetupProjectWebhookWithManager(mgr, versionCatalogKey); err != nil {
+					setupLog.Error(err, "unable to create webhook", "webhook", "Project")
+					os.Exit(1)
+				}
+				if err = webhookv1alpha1.SetupClusterWebhookWithManager(mgr); err != nil {
+					setupLog.Error(err, "unable to create webhook", "webhook", "Cluster")
+					os.Exit(1)
+				}
+				if err = webhookv1alpha1.SetupAddonWebhookWithManager(mgr); err != nil {
+					setupLog.Error(err, "unable to create webhook", "webhook", "Addon")
+					os.Exit(1)
+				}
+			}
 			//+kubebuilder:scaffold:builder
 
 			if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -118,6 +217,15 @@ func init() {
 	//utilruntime.Must(crossplanek8s.AddToScheme(scheme))
 }
 
+// parseNamespacedName parses a namespace/name flag value
+func parseNamespacedName(value string) (types.NamespacedName, error) {
+	namespace, name, found := strings.Cut(value, "/")
+	if !found || namespace == "" || name == "" {
+		return types.NamespacedName{}, fmt.Errorf("expected namespace/name, got %q", value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)